@@ -17,15 +17,30 @@ import (
 	"golang.org/x/exp/slog"
 
 	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/barbicansecret"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/catalogendpoints"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/cindercreatevolume"
 	"github.com/boyvinall/openstack-check-exporter/pkg/checks/cinderservices"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/designaterecordset"
 	"github.com/boyvinall/openstack-check-exporter/pkg/checks/glancelist"
 	"github.com/boyvinall/openstack-check-exporter/pkg/checks/glanceshow"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/glanceuploadimage"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/heatcreatestack"
 	"github.com/boyvinall/openstack-check-exporter/pkg/checks/horizonlogin"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/keystonetoken"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/manilacreateshare"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/neutronagents"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/neutroncreatenetwork"
 	"github.com/boyvinall/openstack-check-exporter/pkg/checks/neutronfloatingip"
 	"github.com/boyvinall/openstack-check-exporter/pkg/checks/neutronlistnetworks"
 	"github.com/boyvinall/openstack-check-exporter/pkg/checks/novacreateinstance"
 	"github.com/boyvinall/openstack-check-exporter/pkg/checks/novalistflavors"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/novaservergroup"
 	"github.com/boyvinall/openstack-check-exporter/pkg/checks/novaservices"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/octaviacreateloadbalancer"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/placementheadroom"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/quotaheadroom"
+	"github.com/boyvinall/openstack-check-exporter/pkg/checks/swiftobjectroundtrip"
 	"github.com/boyvinall/openstack-check-exporter/pkg/history"
 	"github.com/boyvinall/openstack-check-exporter/pkg/metrics"
 )
@@ -122,6 +137,7 @@ func once(managers []*checker.CheckManager, checks []string) error {
 
 			fmt.Println("Start   ", r.Start.UTC().Format(time.RFC3339))
 			fmt.Println("Cloud   ", r.Cloud)
+			fmt.Println("Region  ", r.Region)
 			fmt.Println("Name    ", r.Name)
 			fmt.Println("Error   ", r.Error)
 			fmt.Println("Duration", r.Duration.Truncate(time.Millisecond))
@@ -137,6 +153,22 @@ func once(managers []*checker.CheckManager, checks []string) error {
 	return nil
 }
 
+func cleanup(managers []*checker.CheckManager, dryRun bool) error {
+	ctx := context.Background()
+	failed := 0
+	for _, mgr := range managers {
+		err := mgr.Cleanup(ctx, dryRun, os.Stdout)
+		if err != nil {
+			slog.Error("cleanup failed", "cloud", mgr.GetCloud(), "error", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("cleanup failed for %d clouds", failed)
+	}
+	return nil
+}
+
 func createManagers(settingsFile string, clouds ...string) ([]*checker.CheckManager, error) {
 	settings, err := checker.LoadSettingsFromFile(settingsFile)
 	if err != nil {
@@ -149,13 +181,28 @@ func createManagers(settingsFile string, clouds ...string) ([]*checker.CheckMana
 		mgr, err := checker.New(cloud, cloudOpts, []checker.CheckerFactory{
 			glancelist.New,
 			glanceshow.New,
+			glanceuploadimage.New,
 			cinderservices.New,
+			cindercreatevolume.New,
 			neutronlistnetworks.New,
 			novalistflavors.New,
 			neutronfloatingip.New,
+			neutroncreatenetwork.New,
 			novacreateinstance.New,
 			novaservices.New,
+			novaservergroup.New,
+			neutronagents.New,
+			placementheadroom.New,
+			quotaheadroom.New,
 			horizonlogin.New,
+			keystonetoken.New,
+			catalogendpoints.New,
+			octaviacreateloadbalancer.New,
+			heatcreatestack.New,
+			designaterecordset.New,
+			swiftobjectroundtrip.New,
+			barbicansecret.New,
+			manilacreateshare.New,
 		})
 		if err != nil {
 			return nil, err
@@ -206,6 +253,28 @@ func main() {
 				return once(managers, c.Args().Slice())
 			},
 		},
+		{
+			Name:  "cleanup",
+			Usage: "delete any resources left behind by the checks",
+			Description: strings.Join([]string{
+				"Finds the servers, floating IPs, volumes, networks, images etc. that were created by the checks",
+				"from this exporter instance, and deletes them in dependency order.  Stop any exporter with the",
+				"same instance_id first, otherwise resources from checks that are in progress will be deleted too.",
+			}, "\n"),
+			Action: func(c *cli.Context) error {
+				managers, err := createManagers(c.String("settings-file"), c.StringSlice("cloud")...)
+				if err != nil {
+					return err
+				}
+				return cleanup(managers, c.Bool("dry-run"))
+			},
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only show what would be deleted",
+				},
+			},
+		},
 		{
 			Name:  "show-cloud-options",
 			Usage: "Read settings.yaml and show the resultant options for the given cloud",
@@ -4,16 +4,29 @@ package checker
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
 	"github.com/gophercloud/utils/openstack/clientconfig"
 	"golang.org/x/exp/slog"
 	"golang.org/x/sync/errgroup"
 )
 
+// ErrAuthentication is wrapped around any error from authenticating before running a check,
+// so that an authentication failure can be distinguished from a failure of the check itself.
+var ErrAuthentication = errors.New("authentication failed")
+
+// ErrNotConfigured can be returned by a CheckerFactory when the check requires some
+// settings that have not been provided.  The check is then skipped, rather than failing.
+var ErrNotConfigured = errors.New("not configured")
+
 // CheckerFactory creates a new `Checker` instance
 type CheckerFactory func(authOpts *gophercloud.AuthOptions, opts CloudOptions) (Checker, error) //nolint:revive // checker.CheckerFactory is fine
 
@@ -27,12 +40,16 @@ type Checker interface {
 // CheckResult stores the result from Checker.Check.
 // It is used to produce metrics or display results.
 type CheckResult struct {
-	Cloud    string
-	Name     string
-	Error    error
-	Start    time.Time
-	Duration time.Duration
-	Output   string
+	Cloud      string
+	Region     string
+	Name       string
+	Error      error
+	Start      time.Time
+	Duration   time.Duration
+	Output     string
+	Steps      []Step
+	Transcript []HTTPExchange
+	Metrics    []Metric
 }
 
 // CheckResultCallback is a callback function that is called for each CheckResult.
@@ -44,8 +61,13 @@ type CheckManager struct {
 	authOpts *gophercloud.AuthOptions
 	opts     CloudOptions
 	checks   []Checker
+	disabled []Checker // not run, but their resources are still removed by Cleanup
+	janitor  *janitor
 	cloud    string
-	region   string
+	region   string // from clouds.yaml or OS_REGION_NAME, unless the regions setting is used
+
+	catalogLock    sync.Mutex
+	catalogRegions []string // discovered from the service catalog, see getRegions
 }
 
 // New creates a new CheckManager instance
@@ -73,82 +95,130 @@ func New(cloud string, opts CloudOptions, factories []CheckerFactory) (*CheckMan
 		cloud:    cloud,
 		region:   region,
 	}
-	for i := range factories {
-		checkfactory := factories[i]
-		check, err := checkfactory(authOpts, opts)
+	err = cm.createChecks(factories)
+	if err != nil {
+		return nil, err
+	}
+
+	// the janitor deletes any resources that were leaked by the other checks, if enabled
+	janitorEnabled := false
+	if _, err := opts.Bool(janitorName, "enabled", &janitorEnabled); err != nil {
+		return nil, err
+	}
+	if janitorEnabled {
+		cm.janitor, err = newJanitor(cloud, opts, cm.checks, cm.getInstanceID)
 		if err != nil {
 			return nil, err
 		}
-		cm.checks = append(cm.checks, check)
+		cm.checks = append(cm.checks, cm.janitor)
 	}
 
 	return cm, nil
 }
 
-// Run runs all registered checks in parallel and calls the callback function for each result
+// Run runs all registered checks in parallel, in each of their regions, and calls the callback function for each result
 func (cm *CheckManager) Run(ctx context.Context, callback CheckResultCallback, checks ...string) error {
-
 	g := errgroup.Group{}
 	for _, c := range cm.getChecksToRun(checks...) {
-		check := c // loop invariant
+		regions, discovered, err := cm.getRegions(c.GetName())
+		if err != nil {
+			return err
+		}
+		for _, rgn := range regions {
+			check := c    // loop invariant
+			region := rgn // loop invariant
 
-		g.Go(func() error {
-			interval := 60
-			timeout := interval
-			if _, err := cm.opts.Int(check.GetName(), "interval", &interval); err != nil {
-				return err
-			}
-			if _, err := cm.opts.Int(check.GetName(), "timeout", &timeout); err != nil {
-				return err
-			}
-			ticker := time.NewTicker(time.Duration(interval) * time.Second)
-			for {
-				// Run the check immediately
-
-				slog.Debug("running check",
-					"check", check.GetName(),
-					"interval", interval,
-					"timeout", timeout,
-				)
-
-				var output bytes.Buffer
-				start := time.Now()
-
-				// We consciously create a new client from scratch on each run instead
-				// of re-authenticating a client across multiple runs.  This allows us to
-				// verify the token workflow more like a real client would.
-				providerClient, err := cm.createAuthenticatedClient()
-				if err == nil {
-					checkCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
-					err = check.Check(checkCtx, providerClient, cm.region, &output)
-					cancel()
-				}
+			g.Go(func() error {
+				return cm.runCheck(ctx, check, region, discovered, callback)
+			})
+		}
+	}
+	return g.Wait()
+}
 
-				// callback even if we failed to create the providerClient
-				done := callback(CheckResult{
-					Cloud:    cm.cloud,
-					Name:     check.GetName(),
-					Error:    err,
-					Start:    start,
-					Duration: time.Since(start),
-					Output:   output.String(),
-				})
-				if done {
-					return nil
-				}
+// runCheck runs a single check in a single region at every interval, until the context is done or the callback returns true.
+// If the region was discovered from the catalog and the first run finds no endpoint for the check, then the check is not
+// run in that region again.
+func (cm *CheckManager) runCheck(ctx context.Context, check Checker, region string, discovered bool, callback CheckResultCallback) error {
+	interval := 60
+	timeout := interval
+	if _, err := cm.opts.Int(check.GetName(), "interval", &interval); err != nil {
+		return err
+	}
+	if _, err := cm.opts.Int(check.GetName(), "timeout", &timeout); err != nil {
+		return err
+	}
+	instanceID, err := cm.getInstanceID(check.GetName())
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	for first := true; ; first = false {
+		// Run the check immediately
 
-				// Wait for the next interval, or until the context is done
+		slog.Debug("running check",
+			"check", check.GetName(),
+			"region", region,
+			"interval", interval,
+			"timeout", timeout,
+		)
 
-				select {
-				case <-ctx.Done():
-					return nil
+		var output bytes.Buffer
+		start := time.Now()
+		r := &run{
+			id:       newRunID(),
+			instance: instanceID,
+			check:    check.GetName(),
+			region:   region,
+			janitor:  cm.janitor,
+		}
 
-				case <-ticker.C:
-				}
-			}
+		// We consciously create a new client from scratch on each run instead
+		// of re-authenticating a client across multiple runs.  This allows us to
+		// verify the token workflow more like a real client would.
+		providerClient, err := cm.createAuthenticatedClient(r)
+		if err != nil {
+			err = fmt.Errorf("%w: %v", ErrAuthentication, err)
+		} else {
+			checkCtx, cancel := context.WithTimeout(withRun(ctx, r), time.Duration(timeout)*time.Second)
+			err = check.Check(checkCtx, providerClient, region, &output)
+			cancel()
+			r.finish()
+		}
+		end := time.Now()
+
+		if first && discovered && isEndpointNotFound(err) {
+			slog.Info("skipping check", "cloud", cm.cloud, "check", check.GetName(), "region", region, "reason", err)
+			ticker.Stop()
+			return nil
+		}
+
+		// callback even if we failed to create the providerClient
+		done := callback(CheckResult{
+			Cloud:      cm.cloud,
+			Region:     region,
+			Name:       check.GetName(),
+			Error:      err,
+			Start:      start,
+			Duration:   end.Sub(start),
+			Output:     output.String(),
+			Steps:      r.getSteps(end),
+			Transcript: r.getTranscript(),
+			Metrics:    r.getMetrics(),
 		})
+		if done {
+			return nil
+		}
+
+		// Wait for the next interval, or until the context is done
+
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+		}
 	}
-	return g.Wait()
 }
 
 // GetCloud returns the cloud that this manager has been configured for
@@ -174,12 +244,103 @@ func (cm *CheckManager) getChecksToRun(checks ...string) []Checker {
 	return checksToRun
 }
 
-func (cm *CheckManager) createAuthenticatedClient() (*gophercloud.ProviderClient, error) {
+// getInstanceID returns the instance ID that the check stamps onto the resources it creates
+func (cm *CheckManager) getInstanceID(check string) (string, error) {
+	instanceID := defaultInstanceID()
+	if _, err := cm.opts.String(check, "instance_id", &instanceID); err != nil {
+		return "", err
+	}
+	if instanceID == "" || len(instanceID) > maxInstanceIDLength {
+		return "", fmt.Errorf("%s/instance_id must be between 1 and %d characters", check, maxInstanceIDLength)
+	}
+	return instanceID, nil
+}
+
+// getRegions returns the regions to run the check in, from the regions setting of the check or else of the
+// whole cloud.  If the setting includes "*", then all regions are discovered from the service catalog and true is
+// returned.  If the setting is empty, then the region from clouds.yaml or OS_REGION_NAME is used.
+func (cm *CheckManager) getRegions(check string) ([]string, bool, error) {
+	var regions []string
+	found, err := cm.opts.Strings(check, "regions", &regions)
+	if err != nil {
+		return nil, false, err
+	}
+	if !found {
+		if _, err = cm.opts.Strings(global, "regions", &regions); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(regions) == 0 {
+		return []string{cm.region}, false, nil
+	}
+	for _, region := range regions {
+		if region == "*" {
+			regions, err = cm.discoverRegions()
+			return regions, true, err
+		}
+	}
+	return regions, false, nil
+}
+
+// discoverRegions returns all the regions in the service catalog.  The catalog is only fetched once.
+func (cm *CheckManager) discoverRegions() ([]string, error) {
+	cm.catalogLock.Lock()
+	defer cm.catalogLock.Unlock()
+	if cm.catalogRegions != nil {
+		return cm.catalogRegions, nil
+	}
+
+	providerClient, err := cm.createAuthenticatedClient(&run{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+	result, ok := providerClient.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return nil, errors.New("no keystone v3 auth result available to discover regions")
+	}
+	catalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var regions []string
+	for i := range catalog.Entries {
+		for j := range catalog.Entries[i].Endpoints {
+			region := catalog.Entries[i].Endpoints[j].RegionID
+			if region == "" {
+				region = catalog.Entries[i].Endpoints[j].Region
+			}
+			if region != "" && !seen[region] {
+				seen[region] = true
+				regions = append(regions, region)
+			}
+		}
+	}
+	if len(regions) == 0 {
+		return nil, errors.New("no regions found in the service catalog")
+	}
+	sort.Strings(regions)
+	slog.Info("discovered regions", "cloud", cm.cloud, "regions", regions)
+	cm.catalogRegions = regions
+	return regions, nil
+}
+
+// isEndpointNotFound returns true if the error is because the service catalog has no endpoint for a service,
+// e.g. because the service is not deployed in the region
+func isEndpointNotFound(err error) bool {
+	var e *gophercloud.ErrEndpointNotFound
+	var e2 gophercloud.ErrEndpointNotFound
+	return errors.As(err, &e) || errors.As(err, &e2)
+}
+
+// createAuthenticatedClient returns a new authenticated client, recording all HTTP requests in the transcript for the given run
+func (cm *CheckManager) createAuthenticatedClient(r *run) (*gophercloud.ProviderClient, error) {
 	providerClient, err := openstack.NewClient(cm.authOpts.IdentityEndpoint)
 	if err != nil {
 		return nil, err
 	}
-	providerClient.HTTPClient = newHTTPClient()
+	providerClient.HTTPClient = newHTTPClient(r)
 
 	err = openstack.Authenticate(providerClient, *cm.authOpts)
 	if err != nil {
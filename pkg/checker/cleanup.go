@@ -0,0 +1,163 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// cleanupOrder lists resource types in the order they must be deleted, so that e.g. servers are gone before
+// the networks they are attached to.  Subnets and ports are deleted along with their network.  Types that
+// are not listed are deleted last.
+var cleanupOrder = []string{
+	"stack",
+	"loadbalancer",
+	"server",
+	"floating_ip",
+	"server_group",
+	"volume",
+	"share",
+	"image",
+	"router",
+	"network",
+	"recordset",
+	"secret",
+	"object",
+}
+
+// cleanupPriority returns the position of the resource type in cleanupOrder
+func cleanupPriority(resourceType string) int {
+	for i, t := range cleanupOrder {
+		if t == resourceType {
+			return i
+		}
+	}
+	return len(cleanupOrder)
+}
+
+// ownedResource is a Resource along with the check that created it
+type ownedResource struct {
+	Resource
+	check string
+	owner ResourceOwner
+}
+
+// Cleanup finds all the resources that were created by the checks from this exporter instance, including checks
+// that are disabled, and deletes them in dependency order, in each of the regions of the checks.  Unlike the
+// janitor, it ignores the grace period, so it should not be run while an exporter with the same instance_id is
+// running checks against the cloud.  If dryRun is set, then the resources are only printed.
+func (cm *CheckManager) Cleanup(ctx context.Context, dryRun bool, w io.Writer) error {
+	checks := make([]Checker, 0, len(cm.checks)+len(cm.disabled))
+	checks = append(checks, cm.checks...)
+	checks = append(checks, cm.disabled...)
+
+	// find the checks to clean up in each region, keeping the regions in the order they are first seen
+	var regions []string
+	regionChecks := make(map[string][]Checker)
+	for _, check := range checks {
+		if _, ok := check.(ResourceOwner); !ok {
+			continue
+		}
+		checkRegions, _, err := cm.getRegions(check.GetName())
+		if err != nil {
+			return err
+		}
+		for _, region := range checkRegions {
+			if regionChecks[region] == nil {
+				regions = append(regions, region)
+			}
+			regionChecks[region] = append(regionChecks[region], check)
+		}
+	}
+
+	providerClient, err := cm.createAuthenticatedClient(&run{})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAuthentication, err)
+	}
+
+	failed := 0
+	for _, region := range regions {
+		n, err := cm.cleanupRegion(ctx, providerClient, region, regionChecks[region], dryRun, w)
+		if err != nil {
+			return err
+		}
+		failed += n
+	}
+	if failed > 0 {
+		return fmt.Errorf("%s: failed to delete %d resources", cm.cloud, failed)
+	}
+	return nil
+}
+
+// cleanupRegion deletes the resources in a single region, returning the number that could not be listed or deleted
+func (cm *CheckManager) cleanupRegion(ctx context.Context, providerClient *gophercloud.ProviderClient, region string,
+	checks []Checker, dryRun bool, w io.Writer) (int, error) {
+
+	// find everything first, so that we can delete in dependency order across all of the checks
+
+	var found []ownedResource
+	failed := 0
+	for _, check := range checks {
+		owner := check.(ResourceOwner)
+		instanceID, err := cm.getInstanceID(check.GetName())
+		if err != nil {
+			return 0, err
+		}
+		listCtx := withOwnerFilter(ctx, Owner{Instance: instanceID, Check: check.GetName()})
+		resources, err := owner.ListResources(listCtx, providerClient, region)
+		if isEndpointNotFound(err) {
+			fmt.Fprintln(w, cm.cloud, region, check.GetName(), "skipped:", err)
+			continue
+		}
+		if err != nil {
+			// carry on with the other checks, so that one broken service doesn't stop the cleanup
+			fmt.Fprintln(w, cm.cloud, region, check.GetName(), "unable to list resources:", err)
+			failed++
+			continue
+		}
+		for _, r := range resources {
+			found = append(found, ownedResource{Resource: r, check: check.GetName(), owner: owner})
+		}
+	}
+	sort.SliceStable(found, func(a, b int) bool {
+		return cleanupPriority(found[a].Type) < cleanupPriority(found[b].Type)
+	})
+
+	if len(found) == 0 {
+		fmt.Fprintln(w, cm.cloud, region, "no resources found")
+		return failed, nil
+	}
+
+	for _, r := range found {
+		created := "unknown"
+		if !r.Created.IsZero() {
+			created = r.Created.UTC().Format(time.RFC3339)
+		}
+		action := "deleting"
+		if dryRun {
+			action = "would delete"
+		}
+		fmt.Fprintln(w, cm.cloud, region, r.check, action, r.Type, r.ID, r.Name, "created", created)
+		if dryRun {
+			continue
+		}
+
+		var output bytes.Buffer
+		err := r.owner.DeleteResource(ctx, providerClient, region, r.Resource, &output)
+		if output.Len() > 0 {
+			fmt.Fprintln(w, "  "+strings.ReplaceAll(strings.TrimSpace(output.String()), "\n", "\n  "))
+		}
+		if err != nil {
+			fmt.Fprintln(w, cm.cloud, region, r.check, "unable to delete", r.Type, r.ID+":", err)
+			failed++
+		}
+	}
+
+	return failed, nil
+}
@@ -1,28 +1,79 @@
 package checker
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"golang.org/x/exp/slog"
 )
 
+// maxTranscriptBody is the maximum number of bytes of each request/response body that is kept in the transcript
+const maxTranscriptBody = 2048
+
+var (
+	// redactJSON matches JSON string values for keys that might contain credentials.  The closing quote
+	// is optional because the body might have been truncated part-way through the value.
+	redactJSON = regexp.MustCompile(`("(?i:password|adminpass|secret|token|access_token|id_token|payload|csrfmiddlewaretoken)"\s*:\s*)"(?:[^"\\]|\\.)*(?:"|$)`)
+
+	// redactTokenID matches the ID of a token object, e.g. {"token": {"id": "gAAAA..."}} when authenticating with
+	// an existing token.  Nested objects are not searched, so e.g. the user ID in a token response is kept.
+	redactTokenID = regexp.MustCompile(`("(?i:token)"\s*:\s*\{[^{}]*?"id"\s*:\s*)"(?:[^"\\]|\\.)*(?:"|$)`)
+
+	// redactForm matches form-encoded values for keys that might contain credentials
+	redactForm = regexp.MustCompile(`((?:^|&)(?i:password|csrfmiddlewaretoken)=)[^&]*`)
+)
+
+// HTTPExchange records a single HTTP request/response made during a check run
+type HTTPExchange struct {
+	Start        time.Time
+	Method       string
+	URL          string
+	StatusCode   int
+	Latency      time.Duration
+	RequestID    string
+	RequestBody  string
+	ResponseBody string
+	Error        error
+}
+
 // LogRoundTripper satisfies the http.RoundTripper interface and is used to
 // customize the default Gophercloud RoundTripper to allow for logging.
 type LogRoundTripper struct {
 	rt                http.RoundTripper
 	numReauthAttempts int
+	run               *run // where to record the transcript, may be nil
+	direct            bool // the requests are made directly by a check, so gophercloud never re-authenticates them
 }
 
 // RoundTrip performs a round-trip HTTP request and logs relevant information about it.
 func (lrt *LogRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	exchange := HTTPExchange{
+		Start:  time.Now(),
+		Method: request.Method,
+		URL:    request.URL.String(),
+	}
+	if lrt.run != nil {
+		exchange.RequestBody = requestBody(request)
+		defer func() {
+			lrt.run.addExchange(&exchange)
+		}()
+	}
+
 	response, err := lrt.rt.RoundTrip(request)
+	exchange.Latency = time.Since(exchange.Start)
 	if response == nil {
 		slog.Error("http roundtrip failed",
 			"url", request.URL.String(),
 			"error", err,
 		)
+		exchange.Error = err
 		return nil, err
 	}
 	slog.Debug("request",
@@ -30,9 +81,20 @@ func (lrt *LogRoundTripper) RoundTrip(request *http.Request) (*http.Response, er
 		"statuscode", response.StatusCode,
 	)
 
-	if response.StatusCode == http.StatusUnauthorized {
+	exchange.StatusCode = response.StatusCode
+	exchange.RequestID = response.Header.Get("X-Openstack-Request-Id")
+	if exchange.RequestID == "" {
+		exchange.RequestID = response.Header.Get("X-Compute-Request-Id")
+	}
+	if lrt.run != nil {
+		exchange.ResponseBody = responseBody(response)
+	}
+
+	if response.StatusCode == http.StatusUnauthorized && !lrt.direct {
 		if lrt.numReauthAttempts >= 3 {
-			return response, errors.New("tried to re-authenticate 3 times with no success")
+			err = errors.New("tried to re-authenticate 3 times with no success")
+			exchange.Error = err
+			return response, err
 		}
 		lrt.numReauthAttempts++
 	}
@@ -40,12 +102,116 @@ func (lrt *LogRoundTripper) RoundTrip(request *http.Request) (*http.Response, er
 	return response, nil
 }
 
+// requestBody returns a redacted/truncated copy of the request body, without consuming it
+func requestBody(request *http.Request) string {
+	if request.Body == nil || request.Body == http.NoBody {
+		return ""
+	}
+	if !isText(request.Header.Get("Content-Type")) {
+		return fmt.Sprintf("(%s body)", request.Header.Get("Content-Type"))
+	}
+
+	var body io.ReadCloser
+	if request.GetBody != nil {
+		var err error
+		body, err = request.GetBody()
+		if err != nil {
+			return fmt.Sprintf("(unable to read body: %v)", err)
+		}
+	} else {
+		// no way to get a fresh copy, so read it all and replace the original
+		b, err := io.ReadAll(request.Body)
+		_ = request.Body.Close()
+		request.Body = io.NopCloser(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Sprintf("(unable to read body: %v)", err)
+		}
+		body = io.NopCloser(bytes.NewReader(b))
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(body, maxTranscriptBody+1))
+	if err != nil {
+		return fmt.Sprintf("(unable to read body: %v)", err)
+	}
+	return redact(b)
+}
+
+// responseBody returns a redacted/truncated copy of the start of the response body.  Only the part
+// that we record is read; the response body is replaced so that the caller still sees the whole body.
+func responseBody(response *http.Response) string {
+	if response.Body == nil || response.Body == http.NoBody {
+		return ""
+	}
+	if response.Request != nil && strings.HasSuffix(response.Request.URL.Path, "/payload") {
+		return "(secret payload redacted)" // e.g. barbican secrets
+	}
+	if !isText(response.Header.Get("Content-Type")) {
+		return fmt.Sprintf("(%s body)", response.Header.Get("Content-Type"))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(response.Body, maxTranscriptBody+1))
+	response.Body = struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.MultiReader(bytes.NewReader(b), response.Body),
+		Closer: response.Body,
+	}
+	if err != nil {
+		return fmt.Sprintf("(unable to read body: %v)", err)
+	}
+	return redact(b)
+}
+
+// isText returns true if the content type is something we can usefully show in the transcript
+func isText(contentType string) bool {
+	for _, t := range []string{"json", "text", "xml", "html", "x-www-form-urlencoded"} {
+		if strings.Contains(contentType, t) {
+			return true
+		}
+	}
+	return contentType == ""
+}
+
+// redact truncates the body and removes anything that looks like a credential
+func redact(b []byte) string {
+	truncated := len(b) > maxTranscriptBody
+	if truncated {
+		b = b[:maxTranscriptBody]
+	}
+	b = redactJSON.ReplaceAll(b, []byte(`$1"***"`))
+	b = redactTokenID.ReplaceAll(b, []byte(`$1"***"`))
+	b = redactForm.ReplaceAll(b, []byte(`$1***`))
+	s := string(b)
+	if truncated {
+		s += "...(truncated)"
+	}
+	return s
+}
+
 // newHTTPClient return a custom HTTP client that allows for logging relevant
-// information before and after the HTTP request.
-func newHTTPClient() http.Client {
+// information before and after the HTTP request.  If r is non-nil, then each
+// request/response is recorded in the transcript for the check run.
+func newHTTPClient(r *run) http.Client {
 	return http.Client{
 		Transport: &LogRoundTripper{
-			rt: http.DefaultTransport,
+			rt:  http.DefaultTransport,
+			run: r,
+		},
+		Timeout: 20 * time.Second,
+	}
+}
+
+// NewHTTPClient returns an HTTP client for requests that a check makes directly rather than through gophercloud,
+// e.g. to probe an endpoint.  Requests are recorded in the transcript of the current run, but unlike the client of
+// the ProviderClient, any number of 401 responses are allowed.
+func NewHTTPClient(ctx context.Context) *http.Client {
+	return &http.Client{
+		Transport: &LogRoundTripper{
+			rt:     http.DefaultTransport,
+			run:    getRun(ctx),
+			direct: true,
 		},
 		Timeout: 20 * time.Second,
 	}
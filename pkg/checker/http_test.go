@@ -0,0 +1,100 @@
+package checker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "password",
+			body: `{"auth":{"identity":{"methods":["password"],"password":{"user":{"name":"admin","domain":{"name":"Default"},"password":"s3cr3t"}}}}}`,
+			want: `{"auth":{"identity":{"methods":["password"],"password":{"user":{"name":"admin","domain":{"name":"Default"},"password":"***"}}}}}`,
+		},
+		{
+			name: "application credential",
+			body: `{"auth":{"identity":{"methods":["application_credential"],"application_credential":{"id":"abc123","secret":"s3cr3t"}}}}`,
+			want: `{"auth":{"identity":{"methods":["application_credential"],"application_credential":{"id":"abc123","secret":"***"}}}}`,
+		},
+		{
+			name: "token",
+			body: `{"auth":{"identity":{"methods":["token"],"token":{"id":"gAAAAABk"}}}}`,
+			want: `{"auth":{"identity":{"methods":["token"],"token":{"id":"***"}}}}`,
+		},
+		{
+			name: "token with spaces",
+			body: `{"auth": {"identity": {"methods": ["token"], "token": {"id": "gAAAAABk"}}}}`,
+			want: `{"auth": {"identity": {"methods": ["token"], "token": {"id": "***"}}}}`,
+		},
+		{
+			name: "token response keeps nested IDs",
+			body: `{"token":{"methods":["password"],"user":{"id":"u1","name":"admin"},"audit_ids":["a1"]}}`,
+			want: `{"token":{"methods":["password"],"user":{"id":"u1","name":"admin"},"audit_ids":["a1"]}}`,
+		},
+		{
+			name: "truncated value",
+			body: `{"auth":{"identity":{"methods":["token"],"token":{"id":"gAAAA`,
+			want: `{"auth":{"identity":{"methods":["token"],"token":{"id":"***"`,
+		},
+		{
+			name: "form",
+			body: `username=admin&password=s3cr3t&csrfmiddlewaretoken=abc&region=RegionOne`,
+			want: `username=admin&password=***&csrfmiddlewaretoken=***&region=RegionOne`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact([]byte(tt.body)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactTruncates(t *testing.T) {
+	got := redact([]byte(strings.Repeat("x", maxTranscriptBody+1)))
+	if want := strings.Repeat("x", maxTranscriptBody) + "...(truncated)"; got != want {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestHTTPClientUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	get := func(client *http.Client) error {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	direct := NewHTTPClient(context.Background())
+	for i := 0; i < 5; i++ {
+		if err := get(direct); err != nil {
+			t.Fatalf("direct request %d: %v", i, err)
+		}
+	}
+
+	// the ProviderClient's client gives up once gophercloud has failed to re-authenticate 3 times
+	provider := newHTTPClient(nil)
+	for i := 0; i < 3; i++ {
+		if err := get(&provider); err != nil {
+			t.Fatalf("provider request %d: %v", i, err)
+		}
+	}
+	if err := get(&provider); err == nil {
+		t.Fatalf("provider request succeeded after 3 failed re-authentications")
+	}
+}
@@ -0,0 +1,158 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// namedInstance is a named instance of a check, e.g. nova_create_instance/az1, that runs with its own options
+type namedInstance struct {
+	Checker
+	name string
+}
+
+func (n *namedInstance) GetName() string {
+	return n.name
+}
+
+// namedResourceOwner is a namedInstance of a check that implements ResourceOwner
+type namedResourceOwner struct {
+	*namedInstance
+	ResourceOwner
+}
+
+// newNamedInstance wraps the check so that it reports the name of the instance
+func newNamedInstance(name string, check Checker) Checker {
+	n := &namedInstance{
+		Checker: check,
+		name:    name,
+	}
+	if owner, ok := check.(ResourceOwner); ok {
+		return &namedResourceOwner{
+			namedInstance: n,
+			ResourceOwner: owner,
+		}
+	}
+	return n
+}
+
+// ResourceNamer is implemented by checks that create resources with fixed names, so that settings where a check
+// and its named instances would create resources with the same name can be rejected
+type ResourceNamer interface {
+	// ResourceNames returns the names of the resources that the check creates
+	ResourceNames() []string
+}
+
+// resourceNames records which check uses each resource name, for each base check
+type resourceNames map[string]map[string]string
+
+// add records the resource names of the check, returning an error if another instance of the same base check
+// already uses one of them
+func (names resourceNames) add(base, name string, check Checker) error {
+	namer, ok := check.(ResourceNamer)
+	if !ok {
+		return nil
+	}
+	if names[base] == nil {
+		names[base] = make(map[string]string)
+	}
+	for _, resource := range namer.ResourceNames() {
+		if other, found := names[base][resource]; found {
+			return fmt.Errorf("%s: resource name %s is already used by %s", name, resource, other)
+		}
+		names[base][resource] = name
+	}
+	return nil
+}
+
+// createChecks creates a check from each of the factories, along with any named instances of it that are
+// configured in the settings, e.g. nova_create_instance/az1.  A named instance is created by the same factory
+// as the base check, with the options of the instance in place of those of the base check.
+func (cm *CheckManager) createChecks(factories []CheckerFactory) error {
+	var instances []string
+	for name := range cm.opts {
+		if strings.Contains(name, instanceSeparator) {
+			instances = append(instances, name)
+		}
+	}
+	sort.Strings(instances)
+	created := make(map[string]bool)
+	names := make(resourceNames)
+
+	for _, factory := range factories {
+		check, err := factory(cm.authOpts, cm.opts)
+		if errors.Is(err, ErrNotConfigured) {
+			// the named instances might still be configured, even if the base check is not
+			slog.Info("skipping check", "cloud", cm.cloud, "reason", err)
+			check = nil
+		} else if err != nil {
+			return err
+		} else if err = names.add(check.GetName(), check.GetName(), check); err != nil {
+			return err
+		} else if err = cm.addCheck(check); err != nil {
+			return err
+		}
+
+		for _, name := range instances {
+			base, _, _ := strings.Cut(name, instanceSeparator)
+			if created[name] || (check != nil && check.GetName() != base) {
+				continue
+			}
+			if len(name) > maxCheckNameLength {
+				return fmt.Errorf("%s: the name of a named instance must be at most %d characters, so that it fits in a tag",
+					name, maxCheckNameLength)
+			}
+
+			// If the base check was not configured then we don't know its name, so we have to try to create the
+			// instance.  Only the options for the base check are changed, so any error other than ErrNotConfigured
+			// must have come from the factory for this instance.
+			instance, err := factory(cm.authOpts, cm.opts.forInstance(name))
+			if errors.Is(err, ErrNotConfigured) {
+				if check != nil {
+					created[name] = true
+					slog.Info("skipping check", "cloud", cm.cloud, "check", name, "reason", err)
+				}
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if instance.GetName() != base {
+				continue
+			}
+			created[name] = true
+			if err = names.add(base, name, instance); err != nil {
+				return err
+			}
+			if err = cm.addCheck(newNamedInstance(name, instance)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range instances {
+		if !created[name] {
+			slog.Warn("skipping check", "cloud", cm.cloud, "check", name, "reason", "unknown check, or not configured")
+		}
+	}
+	return nil
+}
+
+// addCheck adds the check to the manager to be run, unless it has been disabled in the settings
+func (cm *CheckManager) addCheck(check Checker) error {
+	enabled := true
+	if _, err := cm.opts.Bool(check.GetName(), "enabled", &enabled); err != nil {
+		return err
+	}
+	if !enabled {
+		slog.Info("skipping check", "cloud", cm.cloud, "check", check.GetName(), "reason", "disabled")
+		cm.disabled = append(cm.disabled, check)
+		return nil
+	}
+	cm.checks = append(cm.checks, check)
+	return nil
+}
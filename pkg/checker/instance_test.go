@@ -0,0 +1,143 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// fakeCheck is a check that creates a single resource with a configurable name
+type fakeCheck struct {
+	name     string
+	resource string
+}
+
+func (c *fakeCheck) GetName() string {
+	return c.name
+}
+
+func (c *fakeCheck) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) error {
+	return nil
+}
+
+func (c *fakeCheck) ResourceNames() []string {
+	return []string{c.resource}
+}
+
+// newFakeFactory returns a CheckerFactory for a fakeCheck, which is not configured unless "configured" is set
+func newFakeFactory(name string) CheckerFactory {
+	return func(authOpts *gophercloud.AuthOptions, opts CloudOptions) (Checker, error) {
+		c := &fakeCheck{name: name, resource: "monitoring-test"}
+		if _, err := opts.ResourceName(name, "resource_name", &c.resource); err != nil {
+			return nil, err
+		}
+		configured := true
+		if _, err := opts.Bool(name, "configured", &configured); err != nil {
+			return nil, err
+		}
+		if !configured {
+			return nil, ErrNotConfigured
+		}
+		return c, nil
+	}
+}
+
+func TestCreateChecks(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    CloudOptions
+		want    map[string]string // check name to resource name
+		wantErr string
+	}{
+		{
+			name: "base check only",
+			opts: CloudOptions{"one": {}},
+			want: map[string]string{"one": "monitoring-test", "two": "monitoring-test"},
+		},
+		{
+			name: "named instances",
+			opts: CloudOptions{
+				"one":   {},
+				"one/a": {},
+				"one/b": {"resource_name": "other"},
+			},
+			want: map[string]string{
+				"one":   "monitoring-test",
+				"one/a": "monitoring-test-a",
+				"one/b": "other-b",
+				"two":   "monitoring-test",
+			},
+		},
+		{
+			name: "disabled base check",
+			opts: CloudOptions{
+				"one":   {"enabled": false},
+				"one/a": {},
+			},
+			want: map[string]string{"one/a": "monitoring-test-a", "two": "monitoring-test"},
+		},
+		{
+			name: "unconfigured base check",
+			opts: CloudOptions{
+				"one":   {"configured": false},
+				"one/a": {"configured": true},
+			},
+			want: map[string]string{"one/a": "monitoring-test-a", "two": "monitoring-test"},
+		},
+		{
+			name: "unconfigured instance",
+			opts: CloudOptions{
+				"one/a": {"configured": false},
+			},
+			want: map[string]string{"one": "monitoring-test", "two": "monitoring-test"},
+		},
+		{
+			name: "unknown check",
+			opts: CloudOptions{"three/a": {}},
+			want: map[string]string{"one": "monitoring-test", "two": "monitoring-test"},
+		},
+		{
+			name:    "instance name too long",
+			opts:    CloudOptions{"one/" + strings.Repeat("a", maxCheckNameLength): {}},
+			wantErr: "must be at most 39 characters",
+		},
+		{
+			name: "duplicate resource name",
+			opts: CloudOptions{
+				"one":   {"resource_name": "monitoring-test-a"},
+				"one/a": {"resource_name": "monitoring-test"},
+			},
+			wantErr: "one/a: resource name monitoring-test-a is already used by one",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := &CheckManager{opts: tt.opts, cloud: "test"}
+			err := cm.createChecks([]CheckerFactory{newFakeFactory("one"), newFakeFactory("two")})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, check := range cm.checks {
+				name := check.GetName()
+				if n, ok := check.(*namedInstance); ok {
+					check = n.Checker
+				}
+				got[name] = check.(*fakeCheck).resource
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
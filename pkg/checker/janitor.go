@@ -0,0 +1,260 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// janitorName is the name of the janitor check, and of its section in settings.yaml
+const janitorName = "janitor"
+
+// Resource is an OpenStack resource that was created by a check
+type Resource struct {
+	// Type is the kind of resource, e.g. "server" or "floating_ip"
+	Type string
+
+	// ID is whatever the owning check needs to delete the resource, normally the OpenStack ID
+	ID string
+
+	// Name is the name of the resource, if it has one
+	Name string
+
+	// Created is when the resource was created, or zero if not known
+	Created time.Time
+}
+
+// ResourceOwner is implemented by a Checker that creates OpenStack resources.  It allows the janitor
+// to find and delete resources that were leaked, e.g. because the exporter was killed part-way through
+// a check or because a delete call failed.
+type ResourceOwner interface {
+	// ResourceTypes returns the types of resource that the check creates, e.g. "server"
+	ResourceTypes() []string
+
+	// ListResources returns the resources that currently exist that might have been created by the check
+	ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]Resource, error)
+
+	// DeleteResource deletes a resource that was returned by ListResources or registered with TrackResource.
+	// It should not return an error if the resource no longer exists.
+	DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r Resource, output *bytes.Buffer) error
+}
+
+// TrackResource registers a resource that was created during the current check run.  If the run finishes
+// without the resource being released, then the janitor treats it as leaked.  If the context was not
+// created by the CheckManager, then this does nothing.
+func TrackResource(ctx context.Context, r Resource) {
+	run := getRun(ctx)
+	if run == nil || run.janitor == nil {
+		return
+	}
+	if r.Created.IsZero() {
+		r.Created = time.Now()
+	}
+	run.janitor.track(run, r)
+}
+
+// ReleaseResource tells the janitor that a resource registered with TrackResource has been deleted
+func ReleaseResource(ctx context.Context, resourceType, id string) {
+	run := getRun(ctx)
+	if run == nil || run.janitor == nil {
+		return
+	}
+	run.janitor.release(run.region, resourceType, id)
+}
+
+// resourceKey uniquely identifies a tracked resource.  IDs are not unique across regions, e.g. swift uses the
+// object name.
+type resourceKey struct {
+	region       string
+	resourceType string
+	id           string
+}
+
+// trackedResource is a resource that was registered with TrackResource
+type trackedResource struct {
+	Resource
+	cloud string
+	check string
+	run   *run // the run that created the resource, nil once the run has finished
+}
+
+// resourceOwner is a ResourceOwner along with the name of the check and the instance ID it stamps on resources
+type resourceOwner struct {
+	check    string
+	instance string
+	owner    ResourceOwner
+}
+
+// janitor is a Checker that finds and deletes resources leaked by other checks
+type janitor struct {
+	cloud       string
+	gracePeriod time.Duration
+	owners      []resourceOwner
+
+	lock    sync.Mutex
+	tracked map[resourceKey]*trackedResource
+}
+
+// newJanitor returns a janitor for the checks that implement ResourceOwner
+func newJanitor(cloud string, opts CloudOptions, checks []Checker, getInstanceID func(check string) (string, error)) (*janitor, error) {
+	gracePeriod := 3600
+	if _, err := opts.Int(janitorName, "grace_period", &gracePeriod); err != nil {
+		return nil, err
+	}
+
+	j := &janitor{
+		cloud:       cloud,
+		gracePeriod: time.Duration(gracePeriod) * time.Second,
+		tracked:     make(map[resourceKey]*trackedResource),
+	}
+	for _, check := range checks {
+		if owner, ok := check.(ResourceOwner); ok {
+			instance, err := getInstanceID(check.GetName())
+			if err != nil {
+				return nil, err
+			}
+			j.owners = append(j.owners, resourceOwner{
+				check:    check.GetName(),
+				instance: instance,
+				owner:    owner,
+			})
+		}
+	}
+	sort.Slice(j.owners, func(a, b int) bool {
+		return j.owners[a].check < j.owners[b].check
+	})
+	return j, nil
+}
+
+func (j *janitor) GetName() string {
+	return janitorName
+}
+
+// Check deletes any leaked resources for each of the ResourceOwner checks, recording the number of
+// leaked resources of each type
+func (j *janitor) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) error {
+	// report zero for every type, so that the metric drops back to zero once a leak has been cleaned up
+	leakedByType := make(map[string]int)
+	for _, o := range j.owners {
+		for _, resourceType := range o.owner.ResourceTypes() {
+			leakedByType[resourceType] = 0
+		}
+	}
+
+	failed := 0
+	for _, o := range j.owners {
+		step := StartStep(ctx, "list "+o.check)
+		found, err := o.owner.ListResources(withOwnerFilter(ctx, Owner{Instance: o.instance, Check: o.check}), providerClient, region)
+		if isEndpointNotFound(err) {
+			// e.g. the service is not deployed in this region, so the check can't have leaked anything here
+			fmt.Fprintln(output, o.check, "skipped:", err)
+			step.End(nil)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintln(output, o.check, "unable to list resources:", err)
+			step.End(err)
+			failed++
+			continue
+		}
+		step.End(nil)
+
+		for _, r := range j.leaked(o.check, region, found, time.Now()) {
+			leakedByType[r.Type]++
+			fmt.Fprintln(output, o.check, "deleting leaked", r.Type, r.ID, r.Name, "created", r.Created.UTC().Format(time.RFC3339))
+			step := StartStep(ctx, "delete "+r.Type) // the ID is in the output, to keep the step label bounded
+			err := step.End(o.owner.DeleteResource(ctx, providerClient, region, r, output))
+			if err != nil {
+				fmt.Fprintln(output, o.check, "unable to delete", r.Type, r.ID+":", err)
+				failed++
+				continue
+			}
+			j.release(region, r.Type, r.ID)
+		}
+	}
+
+	for resourceType, count := range leakedByType {
+		RecordMetric(ctx, Metric{
+			Name: "leaked_resources",
+			Help: "Number of resources leaked by the checks that were found by the janitor",
+			Labels: map[string]string{
+				"type": resourceType,
+			},
+			Value: float64(count),
+		})
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d janitor operations failed", failed)
+	}
+	return nil
+}
+
+// leaked returns the resources for the check that should be deleted.  A resource has leaked if it was
+// registered by a run that has finished, or if it was not registered and is older than the grace period.
+// Resources that belong to a run that is still in progress, or that were not registered and have an unknown
+// age, are never included.
+func (j *janitor) leaked(check, region string, found []Resource, now time.Time) []Resource {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	var leaked []Resource
+	seen := make(map[resourceKey]bool)
+	for _, r := range found {
+		key := resourceKey{region, r.Type, r.ID}
+		seen[key] = true
+		if t, ok := j.tracked[key]; ok {
+			if t.run == nil {
+				leaked = append(leaked, r)
+			}
+			continue
+		}
+		if !r.Created.IsZero() && now.Sub(r.Created) > j.gracePeriod {
+			leaked = append(leaked, r)
+		}
+	}
+
+	// also include any registered resources in this region that the check was not able to list
+	for key, t := range j.tracked {
+		if t.check == check && key.region == region && t.run == nil && !seen[key] {
+			leaked = append(leaked, t.Resource)
+		}
+	}
+
+	return leaked
+}
+
+// track registers a resource created by the given run
+func (j *janitor) track(r *run, resource Resource) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.tracked[resourceKey{r.region, resource.Type, resource.ID}] = &trackedResource{
+		Resource: resource,
+		cloud:    j.cloud,
+		check:    r.check,
+		run:      r,
+	}
+}
+
+// release forgets a resource that has been deleted
+func (j *janitor) release(region, resourceType, id string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	delete(j.tracked, resourceKey{region, resourceType, id})
+}
+
+// finishRun marks all resources that are still registered by the run as leaked
+func (j *janitor) finishRun(r *run) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for _, t := range j.tracked {
+		if t.run == r {
+			t.run = nil
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package checker
+
+import "context"
+
+// Metric is a check-specific value that is exported alongside the standard metrics for each check,
+// e.g. the time taken for an instance to become reachable.
+type Metric struct {
+	// Name is appended to "openstack_check_" to form the prometheus metric name,
+	// so should follow prometheus naming conventions, e.g. "time_to_reachable_seconds".
+	Name string
+
+	// Help describes the metric
+	Help string
+
+	// Labels are added to the standard "name" and "cloud" labels.  Every Metric with
+	// the same Name must use the same set of label keys.
+	Labels map[string]string
+
+	// Value is the current value of the metric
+	Value float64
+}
+
+// RecordMetric records a check-specific metric for the current check run.  If the context was
+// not created by the CheckManager, then the metric is discarded.
+func RecordMetric(ctx context.Context, m Metric) {
+	r := getRun(ctx)
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.metrics = append(r.metrics, m)
+}
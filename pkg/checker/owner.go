@@ -0,0 +1,180 @@
+package checker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+	"time"
+)
+
+// ownerPrefix is prepended to the tag and metadata keys that record the Owner of a resource
+const ownerPrefix = "check-exporter-"
+
+// the tag and metadata keys that record the Owner of a resource
+const (
+	ownerKeyInstance = ownerPrefix + "instance"
+	ownerKeyCheck    = ownerPrefix + "check"
+	ownerKeyRun      = ownerPrefix + "run"
+	ownerKeyCreated  = ownerPrefix + "created"
+)
+
+// maxTagLength is the limit that neutron and nova apply to the length of a tag
+const maxTagLength = 60
+
+// maxInstanceIDLength keeps the instance tag within maxTagLength
+const maxInstanceIDLength = maxTagLength - len(ownerKeyInstance+"=")
+
+// maxCheckNameLength keeps the check tag within maxTagLength, including the instance name of a named instance
+const maxCheckNameLength = maxTagLength - len(ownerKeyCheck+"=")
+
+// Owner identifies the exporter instance, check and run that created a resource.  Checks stamp this onto
+// the resources they create, as tags or metadata, so that the resources can be told apart from those created
+// by another exporter instance or by a human.
+type Owner struct {
+	// Instance identifies the exporter process, from the instance_id setting or the hostname
+	Instance string
+
+	// Check is the name of the check that created the resource
+	Check string
+
+	// Run identifies the run of the check that created the resource
+	Run string
+
+	// Created is when the resource was created
+	Created time.Time
+}
+
+// GetOwner returns the Owner to stamp on a resource that is being created by the current run
+func GetOwner(ctx context.Context) Owner {
+	o := Owner{
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+	if r := getRun(ctx); r != nil {
+		o.Instance = r.instance
+		o.Check = r.check
+		o.Run = r.id
+	}
+	return o
+}
+
+// ownerFilterKey is the context key used to store the Owner that OwnerFilter returns, when the resources are
+// being listed by something other than the check itself, e.g. by the janitor
+type ownerFilterKey struct{}
+
+// withOwnerFilter returns a context for listing the resources that were created by another check
+func withOwnerFilter(ctx context.Context, filter Owner) context.Context {
+	return context.WithValue(ctx, ownerFilterKey{}, filter)
+}
+
+// OwnerFilter returns an Owner that matches all resources created by the current check from this exporter
+// instance, e.g. to find resources left over from a previous run.  When called from ListResources, it matches
+// the check that owns the resources rather than e.g. the janitor.
+func OwnerFilter(ctx context.Context) Owner {
+	if filter, ok := ctx.Value(ownerFilterKey{}).(Owner); ok {
+		return filter
+	}
+	var o Owner
+	if r := getRun(ctx); r != nil {
+		o.Instance = r.instance
+		o.Check = r.check
+	}
+	return o
+}
+
+// Matches returns true if o has the same instance and check as the filter
+func (o Owner) Matches(filter Owner) bool {
+	return o.Instance == filter.Instance && o.Check == filter.Check
+}
+
+// Metadata returns the owner as key/value metadata, e.g. for nova, cinder or manila.  Empty fields are omitted.
+func (o Owner) Metadata() map[string]string {
+	m := make(map[string]string)
+	if o.Instance != "" {
+		m[ownerKeyInstance] = o.Instance
+	}
+	if o.Check != "" {
+		m[ownerKeyCheck] = o.Check
+	}
+	if o.Run != "" {
+		m[ownerKeyRun] = o.Run
+	}
+	if !o.Created.IsZero() {
+		m[ownerKeyCreated] = o.Created.Format(time.RFC3339)
+	}
+	return m
+}
+
+// Tags returns the owner as "key=value" tags, e.g. for neutron, glance, heat or octavia.  Empty fields are omitted.
+func (o Owner) Tags() []string {
+	m := o.Metadata()
+	var tags []string
+	for _, key := range []string{ownerKeyInstance, ownerKeyCheck, ownerKeyRun, ownerKeyCreated} {
+		if value, found := m[key]; found {
+			tags = append(tags, key+"="+value)
+		}
+	}
+	return tags
+}
+
+// String returns the tags separated by spaces, e.g. for a description field
+func (o Owner) String() string {
+	return strings.Join(o.Tags(), " ")
+}
+
+// OwnerFromMetadata parses the owner from resource metadata.  Keys are compared case-insensitively, since
+// e.g. swift returns them in canonical header form.  It returns false if the resource has no owner.
+func OwnerFromMetadata(metadata map[string]string) (Owner, bool) {
+	var o Owner
+	for key, value := range metadata {
+		switch strings.ToLower(key) {
+		case ownerKeyInstance:
+			o.Instance = value
+		case ownerKeyCheck:
+			o.Check = value
+		case ownerKeyRun:
+			o.Run = value
+		case ownerKeyCreated:
+			o.Created, _ = time.Parse(time.RFC3339, value) // zero if invalid
+		}
+	}
+	return o, o.Instance != "" && o.Check != ""
+}
+
+// OwnerFromTags parses the owner from "key=value" tags, ignoring any other tags.  It returns false if the
+// resource has no owner.
+func OwnerFromTags(tags []string) (Owner, bool) {
+	metadata := make(map[string]string)
+	for _, tag := range tags {
+		key, value, found := strings.Cut(tag, "=")
+		if found && strings.HasPrefix(key, ownerPrefix) {
+			metadata[key] = value
+		}
+	}
+	return OwnerFromMetadata(metadata)
+}
+
+// OwnerFromString parses the owner from the output of Owner.String()
+func OwnerFromString(s string) (Owner, bool) {
+	return OwnerFromTags(strings.Fields(s))
+}
+
+// defaultInstanceID returns the instance ID to use when instance_id is not set
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "unknown"
+	}
+	if len(hostname) > maxInstanceIDLength {
+		hostname = hostname[:maxInstanceIDLength]
+	}
+	return hostname
+}
+
+// newRunID returns a random ID for a run of a check
+func newRunID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b) // the ID is only informational, so don't fail the run if this errors
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,78 @@
+package checker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// runKey is the context key used to store the *run for the current check
+type runKey struct{}
+
+// run holds the state that a Checker records during a single run of a check
+type run struct {
+	id       string
+	instance string
+	check    string
+	region   string
+	janitor  *janitor // where to register created resources, may be nil
+
+	lock       sync.Mutex
+	steps      []*Step
+	transcript []HTTPExchange
+	metrics    []Metric
+}
+
+func withRun(ctx context.Context, r *run) context.Context {
+	return context.WithValue(ctx, runKey{}, r)
+}
+
+func getRun(ctx context.Context) *run {
+	r, _ := ctx.Value(runKey{}).(*run)
+	return r
+}
+
+// finish is called once the check has returned
+func (r *run) finish() {
+	if r.janitor != nil {
+		r.janitor.finishRun(r)
+	}
+}
+
+// getSteps returns a copy of the recorded steps.  Any step that was not ended
+// is treated as having run until the given end time.
+func (r *run) getSteps(end time.Time) []Step {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	steps := make([]Step, 0, len(r.steps))
+	for _, s := range r.steps {
+		step := *s
+		if !step.ended {
+			step.Duration = end.Sub(step.Start)
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// addExchange appends an HTTP request/response to the transcript
+func (r *run) addExchange(e *HTTPExchange) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.transcript = append(r.transcript, *e)
+}
+
+// getTranscript returns a copy of the recorded HTTP transcript
+func (r *run) getTranscript() []HTTPExchange {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]HTTPExchange(nil), r.transcript...)
+}
+
+// getMetrics returns a copy of the recorded metrics
+func (r *run) getMetrics() []Metric {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Metric(nil), r.metrics...)
+}
@@ -5,12 +5,21 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
 
 const global = "global"
 
+// instanceSeparator separates the check name from the instance name in a named instance of a check,
+// e.g. nova_create_instance/az1
+const instanceSeparator = "/"
+
+// instanceOption is set by forInstance to the name of the instance, e.g. az1, so that ResourceName can make the
+// names of the resources created by the instance unique
+const instanceOption = "instance"
+
 // CheckOptions is a map of options for a single check
 type CheckOptions map[string]any
 
@@ -47,39 +56,86 @@ func (s *Settings) GetCloudOptions(cloud string) CloudOptions {
 		"timeout":  60,
 	}
 
-	// then overlay global defaults from the settings file
-	for opt := range s.Default[global] {
-		defaultGlobalOpts[opt] = s.Default[global][opt]
-	}
-
-	cloudOpts := make(CloudOptions)
-	for check, opts := range s.Default {
-		if check == global {
-			continue
+	// then overlay global defaults from the settings file, and then the per-cloud global settings
+	for _, checks := range []CloudOptions{s.Default, s.Clouds[cloud]} {
+		for opt := range checks[global] {
+			defaultGlobalOpts[opt] = checks[global][opt]
 		}
+	}
 
-		// make a copy of the global defaults
-		cloudOpts[check] = make(CheckOptions)
-		for key, value := range defaultGlobalOpts {
-			cloudOpts[check][key] = value
+	// named instances are resolved after everything else, so that they can inherit from the base check
+	var instances []string
+	cloudOpts := CloudOptions{
+		global: defaultGlobalOpts.copy(), // for settings that apply to the whole cloud, e.g. regions
+	}
+	for _, checks := range []CloudOptions{s.Default, s.Clouds[cloud]} {
+		for check := range checks {
+			if check == global || cloudOpts[check] != nil {
+				continue
+			}
+			if strings.Contains(check, instanceSeparator) {
+				instances = append(instances, check)
+				continue
+			}
+
+			// make a copy of the global defaults
+			cloudOpts[check] = defaultGlobalOpts.copy()
 		}
+	}
 
-		// then overlay the per-check defaults
-		for key, value := range opts {
-			cloudOpts[check][key] = value
+	// then overlay the per-check defaults, and then the per-cloud settings
+	for _, checks := range []CloudOptions{s.Default, s.Clouds[cloud]} {
+		for check, opts := range checks {
+			if check == global || cloudOpts[check] == nil {
+				continue // named instances are handled below
+			}
+			for key, value := range opts {
+				cloudOpts[check][key] = value
+			}
 		}
 	}
 
-	// then overlay the per-cloud settings
-	for check, opts := range s.Clouds[cloud] {
-		for key, value := range opts {
-			cloudOpts[check][key] = value
+	// a named instance starts with the options of the base check, except for whether it is enabled
+	for _, check := range instances {
+		base, _, _ := strings.Cut(check, instanceSeparator)
+		opts := defaultGlobalOpts.copy()
+		if cloudOpts[base] != nil {
+			opts = cloudOpts[base].copy()
+			delete(opts, "enabled")
+		}
+		for _, checks := range []CloudOptions{s.Default, s.Clouds[cloud]} {
+			for key, value := range checks[check] {
+				opts[key] = value
+			}
 		}
+		cloudOpts[check] = opts
 	}
 
 	return cloudOpts
 }
 
+// copy returns a shallow copy of the options
+func (opts CheckOptions) copy() CheckOptions {
+	c := make(CheckOptions, len(opts))
+	for key, value := range opts {
+		c[key] = value
+	}
+	return c
+}
+
+// forInstance returns a copy of the options where the options of the named instance replace those of the base
+// check, so that the instance can be created by the same CheckerFactory as the base check
+func (opts CloudOptions) forInstance(name string) CloudOptions {
+	base, instance, _ := strings.Cut(name, instanceSeparator)
+	c := make(CloudOptions, len(opts))
+	for check, checkopts := range opts {
+		c[check] = checkopts
+	}
+	c[base] = opts[name].copy()
+	c[base][instanceOption] = instance
+	return c
+}
+
 // Dump prints the settings to stdout
 func (opts CloudOptions) Dump() {
 	for check, checkopts := range opts {
@@ -115,6 +171,20 @@ func (opts CloudOptions) String(checkname, key string, value *string) (bool, err
 	return found, nil
 }
 
+// ResourceName is like String, for an option that names a resource that is created by the check.  For a named
+// instance of the check, "-" and the instance name are appended to the value, e.g. monitoring-test-az1, so that
+// the instance does not collide with the resources of the base check or other instances.
+func (opts CloudOptions) ResourceName(checkname, key string, value *string) (bool, error) {
+	found, err := opts.String(checkname, key, value)
+	if err != nil {
+		return found, err
+	}
+	if instance, ok := opts[checkname][instanceOption].(string); ok && instance != "" && *value != "" {
+		*value += "-" + instance
+	}
+	return found, nil
+}
+
 // Int returns the int value of the given option key for the given checkname in this Openstack cloud.
 //   - If the option is not set, the value is not changed and false is returned.
 //   - If the option is set, the value is set and true is returned.
@@ -152,3 +222,31 @@ func (opts CloudOptions) Bool(checkname, key string, value *bool) (bool, error)
 	*value = s
 	return found, nil
 }
+
+// Strings returns the []string value of the given option key for the given checkname in this Openstack cloud.
+//   - If the option is not set, the value is not changed and false is returned.
+//   - If the option is set, the value is set and true is returned.
+//   - If the option is set but the value is not a list of strings, an error is returned.
+func (opts CloudOptions) Strings(checkname, key string, value *[]string) (bool, error) {
+	v, found := opts[checkname][key]
+	if !found {
+		return found, nil
+	}
+
+	list, ok := v.([]any)
+	if !ok {
+		return found, fmt.Errorf("%s/%s value is not a list", checkname, key)
+	}
+
+	s := make([]string, 0, len(list))
+	for _, item := range list {
+		str, ok := item.(string)
+		if !ok {
+			return found, fmt.Errorf("%s/%s value is not a list of strings", checkname, key)
+		}
+		s = append(s, str)
+	}
+
+	*value = s
+	return found, nil
+}
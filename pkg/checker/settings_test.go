@@ -0,0 +1,198 @@
+package checker
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestGetCloudOptions(t *testing.T) {
+	settings := `
+default:
+  global:
+    interval: 30
+  heat_create_stack:
+    stack_name: monitoring-test
+    timeout: 300
+    enabled: false
+  heat_create_stack/a:
+    interval: 600
+  heat_create_stack/b:
+    enabled: true
+clouds:
+  os1:
+    global:
+      interval: 45
+    heat_create_stack:
+      timeout: 400
+    heat_create_stack/b:
+      interval: 900
+    heat_create_stack/c:
+      stack_name: other
+    cinder_create_volume/d:
+      volume_type: ssd
+`
+	var s Settings
+	if err := yaml.Unmarshal([]byte(settings), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		cloud string
+		check string
+		want  CheckOptions
+	}{
+		{
+			name:  "global defaults",
+			cloud: "os2",
+			check: global,
+			want:  CheckOptions{"interval": 30, "timeout": 60},
+		},
+		{
+			name:  "per-cloud global",
+			cloud: "os1",
+			check: global,
+			want:  CheckOptions{"interval": 45, "timeout": 60},
+		},
+		{
+			name:  "base check",
+			cloud: "os2",
+			check: "heat_create_stack",
+			want:  CheckOptions{"interval": 30, "timeout": 300, "stack_name": "monitoring-test", "enabled": false},
+		},
+		{
+			name:  "base check with per-cloud overlay",
+			cloud: "os1",
+			check: "heat_create_stack",
+			want:  CheckOptions{"interval": 45, "timeout": 400, "stack_name": "monitoring-test", "enabled": false},
+		},
+		{
+			name:  "instance inherits from base check without enabled",
+			cloud: "os2",
+			check: "heat_create_stack/a",
+			want:  CheckOptions{"interval": 600, "timeout": 300, "stack_name": "monitoring-test"},
+		},
+		{
+			name:  "instance inherits per-cloud overlay of base check",
+			cloud: "os1",
+			check: "heat_create_stack/a",
+			want:  CheckOptions{"interval": 600, "timeout": 400, "stack_name": "monitoring-test"},
+		},
+		{
+			name:  "instance with per-cloud overlay",
+			cloud: "os1",
+			check: "heat_create_stack/b",
+			want:  CheckOptions{"interval": 900, "timeout": 400, "stack_name": "monitoring-test", "enabled": true},
+		},
+		{
+			name:  "instance only under clouds",
+			cloud: "os1",
+			check: "heat_create_stack/c",
+			want:  CheckOptions{"interval": 45, "timeout": 400, "stack_name": "other"},
+		},
+		{
+			name:  "instance only under clouds without base check",
+			cloud: "os1",
+			check: "cinder_create_volume/d",
+			want:  CheckOptions{"interval": 45, "timeout": 60, "volume_type": "ssd"},
+		},
+		{
+			name:  "instance of another cloud",
+			cloud: "os2",
+			check: "heat_create_stack/c",
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.GetCloudOptions(tt.cloud)[tt.check]
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("got %v, want no options", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForInstance(t *testing.T) {
+	opts := CloudOptions{
+		"heat_create_stack":   {"stack_name": "monitoring-test", "timeout": 300},
+		"heat_create_stack/a": {"timeout": 600},
+	}
+
+	got := opts.forInstance("heat_create_stack/a")
+
+	want := CheckOptions{"timeout": 600, instanceOption: "a"}
+	if !reflect.DeepEqual(got["heat_create_stack"], want) {
+		t.Errorf("got %v, want %v", got["heat_create_stack"], want)
+	}
+	if _, found := opts["heat_create_stack/a"][instanceOption]; found {
+		t.Errorf("forInstance modified the original options")
+	}
+	if opts["heat_create_stack"]["timeout"] != 300 {
+		t.Errorf("forInstance modified the base check options")
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  CloudOptions
+		value string
+		want  string
+		found bool
+	}{
+		{
+			name:  "default",
+			opts:  CloudOptions{},
+			value: "monitoring-test",
+			want:  "monitoring-test",
+		},
+		{
+			name:  "set",
+			opts:  CloudOptions{"heat_create_stack": {"stack_name": "other"}},
+			value: "monitoring-test",
+			want:  "other",
+			found: true,
+		},
+		{
+			name:  "default for instance",
+			opts:  CloudOptions{"heat_create_stack": {instanceOption: "a"}},
+			value: "monitoring-test",
+			want:  "monitoring-test-a",
+		},
+		{
+			name:  "set for instance",
+			opts:  CloudOptions{"heat_create_stack": {"stack_name": "other", instanceOption: "a"}},
+			value: "monitoring-test",
+			want:  "other-a",
+			found: true,
+		},
+		{
+			name:  "empty for instance",
+			opts:  CloudOptions{"heat_create_stack": {"stack_name": "", instanceOption: "a"}},
+			value: "monitoring-test",
+			want:  "",
+			found: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := tt.value
+			found, err := tt.opts.ResourceName("heat_create_stack", "stack_name", &value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.found || value != tt.want {
+				t.Errorf("got %q, %v, want %q, %v", value, found, tt.want, tt.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package checker
+
+import (
+	"context"
+	"time"
+)
+
+// Step records the timing of a single named phase within a check run,
+// e.g. "create server" or "wait for ACTIVE".
+type Step struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Error    error
+	ended    bool
+}
+
+// End marks the step as complete, recording the given error (if any).
+// The error is returned unchanged so that callers can write `return step.End(err)`.
+func (s *Step) End(err error) error {
+	if s.ended {
+		return err
+	}
+	s.Duration = time.Since(s.Start)
+	s.Error = err
+	s.ended = true
+	return err
+}
+
+// StartStep records the start of a named phase of the current check run.  The caller must call
+// End on the returned Step once the phase is complete.  If the context was not created by the
+// CheckManager then the step is still timed, but it is not recorded anywhere.
+func StartStep(ctx context.Context, name string) *Step {
+	s := &Step{
+		Name:  name,
+		Start: time.Now(),
+	}
+	if r := getRun(ctx); r != nil {
+		r.lock.Lock()
+		r.steps = append(r.steps, s)
+		r.lock.Unlock()
+	}
+	return s
+}
@@ -0,0 +1,37 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCancelled is returned by WaitFor when the context is done before the condition was met
+var ErrCancelled = errors.New("cancelled")
+
+// WaitFor calls condition immediately and then at every interval, until it returns true or an error,
+// or until the context is done.
+//
+// Checks that create resources should not return immediately on ErrCancelled, they should still
+// clean up whatever they have created.
+func WaitFor(ctx context.Context, interval time.Duration, condition func() (bool, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		done, err := condition()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ErrCancelled
+
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Package barbicansecret implements a `checker.Check` that stores/retrieves/deletes a Barbican secret
+package barbicansecret
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/keymanager/v1/secrets"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// errPayloadMismatch is returned when the retrieved payload does not match the payload that was stored
+var errPayloadMismatch = errors.New("retrieved secret payload does not match stored payload")
+
+// resourceSecret is the type of resource that is created by this check
+const resourceSecret = "secret"
+
+type checkBarbicanSecret struct {
+	secretName string
+	autoDelete bool
+}
+
+// New returns a new Checker instance that stores, retrieves and deletes a Barbican secret
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkBarbicanSecret{
+		secretName: "monitoring-test",
+		autoDelete: false,
+	}
+	if _, err := opts.ResourceName(c.GetName(), "secret_name", &c.secretName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
+		return nil, err
+	}
+
+	if c.secretName == "" {
+		return nil, errors.New("secret_name must be non-empty")
+	}
+
+	return c, nil
+}
+
+func (c *checkBarbicanSecret) GetName() string {
+	return "barbican_secret"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkBarbicanSecret) ResourceNames() []string {
+	return []string{c.secretName}
+}
+
+func (c *checkBarbicanSecret) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	barbicanClient, err := openstack.NewKeyManagerV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set barbicanClient.Context so we can cleanup the secret even if the context is cancelled
+
+	// check the secret doesn't already exist
+
+	step := checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(barbicanClient, output)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// generate a random payload
+
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return err
+	}
+	payload := hex.EncodeToString(b)
+
+	// store the secret
+
+	step = checker.StartStep(ctx, "store secret")
+	owner := checker.GetOwner(ctx)
+	secret, err := secrets.Create(barbicanClient, secrets.CreateOpts{
+		Name:               c.secretName,
+		Payload:            payload,
+		PayloadContentType: "text/plain",
+		SecretType:         secrets.OpaqueSecret,
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	secretID := path.Base(secret.SecretRef)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceSecret, ID: secretID, Name: c.secretName, Created: owner.Created})
+	fmt.Fprintln(output, "stored secret", secretID)
+
+	// always delete the secret, even if the context is cancelled
+
+	defer func() {
+		step := checker.StartStep(ctx, "delete secret")
+		e := step.End(secrets.Delete(barbicanClient, secretID).ExtractErr())
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceSecret, secretID)
+			fmt.Fprintln(output, "deleted secret", secretID)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	// barbican doesn't accept metadata when the secret is created, so add it separately
+
+	step = checker.StartStep(ctx, "set metadata")
+	_, err = secrets.CreateMetadata(barbicanClient, secretID, secrets.MetadataOpts(owner.Metadata())).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// retrieve the metadata
+
+	step = checker.StartStep(ctx, "get metadata")
+	s, err := secrets.Get(barbicanClient, secretID).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	if s.Status != "ACTIVE" {
+		return step.End(fmt.Errorf("secret %s is in status %s", secretID, s.Status))
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "secret", secretID, "status", s.Status, "content types", s.ContentTypes)
+
+	// retrieve the payload – this is what exercises the backend (e.g. HSM/vault)
+
+	step = checker.StartStep(ctx, "get payload")
+	retrieved, err := secrets.GetPayload(barbicanClient, secretID, secrets.GetPayloadOpts{
+		PayloadContentType: "text/plain",
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	if string(retrieved) != payload {
+		return step.End(errPayloadMismatch)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "retrieved payload matches, size", len(retrieved))
+
+	return nil
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkBarbicanSecret) ResourceTypes() []string {
+	return []string{resourceSecret}
+}
+
+// ListResources returns the secrets that were created by this check from this exporter instance
+func (c *checkBarbicanSecret) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	barbicanClient, err := openstack.NewKeyManagerV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	barbicanClient.Context = ctx
+
+	allPages, err := secrets.List(barbicanClient, secrets.ListOpts{
+		Name: c.secretName,
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allSecrets, err := secrets.ExtractSecrets(allPages)
+	if err != nil {
+		return nil, err
+	}
+	filter := checker.OwnerFilter(ctx)
+	var resources []checker.Resource
+	for i := range allSecrets {
+		s := &allSecrets[i]
+		secretID := path.Base(s.SecretRef)
+
+		// secrets can't be listed by metadata, so fetch it for each one
+		metadata, err := secrets.GetMetadata(barbicanClient, secretID).Extract()
+		if err != nil {
+			return nil, err
+		}
+		owner, ok := checker.OwnerFromMetadata(metadata)
+		if !ok || !owner.Matches(filter) {
+			continue
+		}
+		resources = append(resources, checker.Resource{Type: resourceSecret, ID: secretID, Name: s.Name, Created: owner.Created})
+	}
+	return resources, nil
+}
+
+// DeleteResource deletes a secret that was created by this check
+func (c *checkBarbicanSecret) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	barbicanClient, err := openstack.NewKeyManagerV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	err = secrets.Delete(barbicanClient, r.ID).ExtractErr()
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	if err == nil {
+		fmt.Fprintln(output, "deleted secret", r.ID)
+	}
+	return err
+}
+
+// deleteExisting checks whether the secret already exists and, if auto_delete is set, deletes it
+func (c *checkBarbicanSecret) deleteExisting(barbicanClient *gophercloud.ServiceClient, output *bytes.Buffer) error {
+	allPages, err := secrets.List(barbicanClient, secrets.ListOpts{
+		Name: c.secretName,
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allSecrets, err := secrets.ExtractSecrets(allPages)
+	if err != nil {
+		return err
+	}
+	if len(allSecrets) == 0 {
+		return nil // all good, go ahead and create it
+	}
+	if !c.autoDelete {
+		return errors.New("secret already exists")
+	}
+	for i := range allSecrets {
+		secretID := path.Base(allSecrets[i].SecretRef)
+		fmt.Fprintln(output, "deleting existing secret", secretID)
+		err = secrets.Delete(barbicanClient, secretID).ExtractErr()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Package catalogendpoints implements a `checker.Check` that probes every endpoint in the keystone service catalog
+package catalogendpoints
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"golang.org/x/exp/slog"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+type checkCatalogEndpoints struct {
+	interfaces []string
+}
+
+// endpointKey identifies an endpoint by the labels of its metrics, so that duplicate endpoints are only probed once
+type endpointKey struct {
+	service string
+	iface   string
+	region  string
+	url     string
+}
+
+// New returns a new Checker instance that probes every endpoint in the service catalog
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkCatalogEndpoints{
+		interfaces: []string{"public"},
+	}
+	if _, err := opts.Strings(c.GetName(), "interfaces", &c.interfaces); err != nil {
+		return nil, err
+	}
+
+	if len(c.interfaces) == 0 {
+		return nil, errors.New("interfaces must be non-empty")
+	}
+	for _, i := range c.interfaces {
+		switch i {
+		case "public", "internal", "admin":
+		default:
+			return nil, fmt.Errorf("invalid interface %q", i)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *checkCatalogEndpoints) GetName() string {
+	return "catalog_endpoints"
+}
+
+func (c *checkCatalogEndpoints) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) error {
+
+	// use the catalog that was returned when the client authenticated
+
+	result, ok := providerClient.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return errors.New("no keystone v3 auth result available")
+	}
+	catalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return err
+	}
+
+	healthy := true
+	count := 0
+	seen := make(map[endpointKey]bool)
+	for i := range catalog.Entries {
+		entry := &catalog.Entries[i]
+		for j := range entry.Endpoints {
+			endpoint := &entry.Endpoints[j]
+			if !c.wanted(endpoint, region) {
+				continue
+			}
+			key := endpointKey{entry.Type, endpoint.Interface, endpointRegion(endpoint), endpoint.URL}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			count++
+			if !c.probeEndpoint(ctx, providerClient, entry, endpoint, output) {
+				healthy = false
+			}
+		}
+	}
+
+	if count == 0 {
+		return errors.New("no endpoints found")
+	}
+
+	if !healthy {
+		return errors.New("catalog endpoints not healthy")
+	}
+
+	return nil
+}
+
+// wanted returns true if the endpoint matches the region and one of the configured interfaces
+func (c *checkCatalogEndpoints) wanted(endpoint *tokens.Endpoint, region string) bool {
+	if region != "" && endpoint.Region != region && endpoint.RegionID != region {
+		return false
+	}
+	for _, i := range c.interfaces {
+		if endpoint.Interface == i {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointRegion returns the region of the endpoint, preferring the region ID
+func endpointRegion(endpoint *tokens.Endpoint) string {
+	if endpoint.RegionID != "" {
+		return endpoint.RegionID
+	}
+	return endpoint.Region
+}
+
+// probeEndpoint makes an unauthenticated and an authenticated version-discovery request to the endpoint,
+// recording the result as metrics.  It returns true if the endpoint is healthy.
+func (c *checkCatalogEndpoints) probeEndpoint(ctx context.Context, providerClient *gophercloud.ProviderClient,
+	entry *tokens.CatalogEntry, endpoint *tokens.Endpoint, output *bytes.Buffer) bool {
+
+	// the region label is already used for the region that the check runs in
+	labels := map[string]string{
+		"service":         entry.Type,
+		"interface":       endpoint.Interface,
+		"endpoint_region": endpointRegion(endpoint),
+		"url":             endpoint.URL,
+	}
+
+	step := checker.StartStep(ctx, fmt.Sprintf("probe %s %s", entry.Type, endpoint.Interface))
+	healthy := true
+	for _, authenticated := range []bool{false, true} {
+		start := time.Now()
+		statusCode, err := probe(ctx, providerClient, endpoint.URL, authenticated)
+		duration := time.Since(start)
+		if err != nil || statusCode >= http.StatusInternalServerError {
+			healthy = false
+		}
+		fmt.Fprintln(output, entry.Type, endpoint.Interface, endpoint.URL, "authenticated", authenticated, "status", statusCode, duration.Round(time.Millisecond), err)
+
+		authLabels := map[string]string{
+			"authenticated": fmt.Sprint(authenticated),
+		}
+		for k, v := range labels {
+			authLabels[k] = v
+		}
+		checker.RecordMetric(ctx, checker.Metric{
+			Name:   "endpoint_status_code",
+			Help:   "HTTP status code from a version-discovery request to the catalog endpoint, or 0 if the request failed",
+			Labels: authLabels,
+			Value:  float64(statusCode),
+		})
+		checker.RecordMetric(ctx, checker.Metric{
+			Name:   "endpoint_duration_seconds",
+			Help:   "How long the version-discovery request to the catalog endpoint took",
+			Labels: authLabels,
+			Value:  duration.Seconds(),
+		})
+	}
+
+	up := 0.0
+	if healthy {
+		up = 1
+		step.End(nil)
+	} else {
+		step.End(errors.New("endpoint not healthy"))
+	}
+	checker.RecordMetric(ctx, checker.Metric{
+		Name:   "endpoint_healthy",
+		Help:   "Whether the catalog endpoint responded without a server error",
+		Labels: labels,
+		Value:  up,
+	})
+
+	return healthy
+}
+
+// probe makes a single GET request to the URL and returns the status code.  Any response below 500 is fine –
+// e.g. some services return 401 for unauthenticated requests or 300 for version discovery.
+func probe(ctx context.Context, providerClient *gophercloud.ProviderClient, url string, authenticated bool) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if authenticated {
+		req.Header.Set("X-Auth-Token", providerClient.Token())
+	}
+
+	// don't use providerClient.HTTPClient, which fails after a few 401 responses because it expects gophercloud to
+	// re-authenticate, whereas unauthenticated requests to many endpoints always return 401
+	resp, err := checker.NewHTTPClient(ctx).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		if e := resp.Body.Close(); e != nil {
+			slog.Error("unable to close body", "error", e)
+		}
+	}()
+
+	return resp.StatusCode, nil
+}
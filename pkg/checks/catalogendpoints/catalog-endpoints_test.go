@@ -0,0 +1,43 @@
+package catalogendpoints
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// failingTransport fails every request, to check that probes don't use the ProviderClient's HTTP client
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("unexpected request through the ProviderClient")
+}
+
+func TestProbeUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	providerClient := &gophercloud.ProviderClient{
+		TokenID:    "token",
+		HTTPClient: http.Client{Transport: failingTransport{}},
+	}
+
+	// e.g. cinder, heat and swift endpoints that include the project ID
+	for i := 0; i < 5; i++ {
+		for _, authenticated := range []bool{false, true} {
+			statusCode, err := probe(context.Background(), providerClient, server.URL+"/v3/project", authenticated)
+			if err != nil {
+				t.Fatalf("probe %d: %v", i, err)
+			}
+			if statusCode != http.StatusUnauthorized {
+				t.Fatalf("probe %d: got status %d, want %d", i, statusCode, http.StatusUnauthorized)
+			}
+		}
+	}
+}
@@ -0,0 +1,346 @@
+// Package cindercreatevolume implements a `checker.Check` that creates/attaches/detaches/deletes a Cinder volume
+package cindercreatevolume
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/volumeattach"
+	utilsservers "github.com/gophercloud/utils/openstack/compute/v2/servers"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// cleanupTimeout is how long we allow for detaching/deleting the volume once the check context is done
+const cleanupTimeout = 2 * time.Minute
+
+// resourceVolume is the type of resource that is created by this check
+const resourceVolume = "volume"
+
+type checkCinderVolume struct {
+	volumeName       string
+	volumeType       string
+	volumeSize       int
+	attachServerName string
+	autoDelete       bool
+}
+
+// New returns a new Checker instance that creates and deletes a Cinder volume
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkCinderVolume{
+		volumeName:       "monitoring-test",
+		volumeType:       "", // use the default volume type
+		volumeSize:       1,
+		attachServerName: "", // don't attach
+		autoDelete:       false,
+	}
+	if _, err := opts.ResourceName(c.GetName(), "volume_name", &c.volumeName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "volume_type", &c.volumeType); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Int(c.GetName(), "volume_size", &c.volumeSize); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "attach_server_name", &c.attachServerName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
+		return nil, err
+	}
+
+	if c.volumeName == "" {
+		return nil, errors.New("volume_name must be non-empty")
+	}
+	if c.volumeSize < 1 {
+		return nil, errors.New("volume_size must be at least 1")
+	}
+
+	return c, nil
+}
+
+func (c *checkCinderVolume) GetName() string {
+	return "cinder_create_volume"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkCinderVolume) ResourceNames() []string {
+	return []string{c.volumeName}
+}
+
+func (c *checkCinderVolume) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+
+	// construct our service clients
+
+	cinderClient, err := openstack.NewBlockStorageV3(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set cinderClient.Context so we can cleanup the volume even if the context is cancelled
+
+	novaClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+
+	// resolve the server name into an ID
+
+	serverID := ""
+	if c.attachServerName != "" {
+		step := checker.StartStep(ctx, "resolve names")
+		serverID, err = utilsservers.IDFromName(novaClient, c.attachServerName)
+		if err != nil {
+			return step.End(err)
+		}
+		step.End(nil)
+	}
+
+	// check the volume doesn't already exist
+
+	step := checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(ctx, cinderClient, novaClient, output)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// create the volume
+
+	step = checker.StartStep(ctx, "create volume")
+	owner := checker.GetOwner(ctx)
+	volume, err := volumes.Create(cinderClient, volumes.CreateOpts{
+		Name:       c.volumeName,
+		Size:       c.volumeSize,
+		VolumeType: c.volumeType,
+		Metadata:   owner.Metadata(),
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceVolume, ID: volume.ID, Name: c.volumeName, Created: owner.Created})
+
+	b, err := json.MarshalIndent(volume, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(output, string(b))
+
+	// always delete the volume, even if the context is cancelled
+
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		step := checker.StartStep(ctx, "delete volume")
+		e := step.End(deleteVolume(cleanupCtx, cinderClient, novaClient, volume.ID, output))
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceVolume, volume.ID)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	// wait for the volume to be available
+
+	step = checker.StartStep(ctx, "wait for available")
+	err = step.End(waitForStatus(ctx, cinderClient, volume.ID, "available"))
+	if err != nil {
+		return err
+	}
+
+	if serverID == "" {
+		return nil
+	}
+
+	// attach and detach the volume
+
+	step = checker.StartStep(ctx, "attach volume")
+	_, err = volumeattach.Create(novaClient, serverID, volumeattach.CreateOpts{
+		VolumeID: volume.ID,
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "attached volume", volume.ID, "to server", serverID)
+
+	step = checker.StartStep(ctx, "wait for in-use")
+	err = step.End(waitForStatus(ctx, cinderClient, volume.ID, "in-use"))
+	if err != nil {
+		return err
+	}
+
+	step = checker.StartStep(ctx, "detach volume")
+	err = step.End(volumeattach.Delete(novaClient, serverID, volume.ID).ExtractErr())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "detached volume", volume.ID, "from server", serverID)
+
+	step = checker.StartStep(ctx, "wait for detached")
+	return step.End(waitForStatus(ctx, cinderClient, volume.ID, "available"))
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkCinderVolume) ResourceTypes() []string {
+	return []string{resourceVolume}
+}
+
+// ListResources returns the volumes that were created by this check from this exporter instance
+func (c *checkCinderVolume) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	cinderClient, err := openstack.NewBlockStorageV3(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	cinderClient.Context = ctx
+
+	filter := checker.OwnerFilter(ctx)
+	allPages, err := volumes.List(cinderClient, volumes.ListOpts{
+		Name:     c.volumeName,
+		Metadata: filter.Metadata(),
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allVolumes, err := volumes.ExtractVolumes(allPages)
+	if err != nil {
+		return nil, err
+	}
+	var resources []checker.Resource
+	for i := range allVolumes {
+		v := &allVolumes[i]
+		owner, ok := checker.OwnerFromMetadata(v.Metadata)
+		if !ok || !owner.Matches(filter) {
+			continue // in case the server ignored the filter
+		}
+		resources = append(resources, checker.Resource{Type: resourceVolume, ID: v.ID, Name: v.Name, Created: owner.Created})
+	}
+	return resources, nil
+}
+
+// DeleteResource deletes a volume that was created by this check
+func (c *checkCinderVolume) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	cinderClient, err := openstack.NewBlockStorageV3(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	novaClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	err = deleteVolume(ctx, cinderClient, novaClient, r.ID, output)
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	return err
+}
+
+// deleteExisting checks whether the volume already exists and, if auto_delete is set, deletes it
+func (c *checkCinderVolume) deleteExisting(ctx context.Context, cinderClient, novaClient *gophercloud.ServiceClient, output *bytes.Buffer) error {
+	allPages, err := volumes.List(cinderClient, volumes.ListOpts{
+		Name: c.volumeName,
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allVolumes, err := volumes.ExtractVolumes(allPages)
+	if err != nil {
+		return err
+	}
+	switch len(allVolumes) {
+	case 0: // all good, go ahead and create it
+
+	case 1:
+		if !c.autoDelete {
+			return errors.New("volume already exists")
+		}
+
+		// delete the existing volume
+		volumeID := allVolumes[0].ID
+		fmt.Fprintln(output, "deleting existing volume", volumeID)
+		return deleteVolume(ctx, cinderClient, novaClient, volumeID, output)
+
+	default:
+		return errors.New("found multiple volumes")
+	}
+
+	return nil
+}
+
+// deleteVolume detaches the volume from any servers, then deletes it and waits for it to be gone
+func deleteVolume(ctx context.Context, cinderClient, novaClient *gophercloud.ServiceClient, volumeID string, output *bytes.Buffer) error {
+	volume, err := volumes.Get(cinderClient, volumeID).Extract()
+	if err != nil {
+		return err
+	}
+
+	if len(volume.Attachments) > 0 {
+		for i := range volume.Attachments {
+			a := &volume.Attachments[i]
+			fmt.Fprintln(output, "detaching volume", volumeID, "from server", a.ServerID)
+			err = volumeattach.Delete(novaClient, a.ServerID, volumeID).ExtractErr()
+			if err != nil {
+				return err
+			}
+		}
+		err = waitForStatus(ctx, cinderClient, volumeID, "available", "error")
+		if err != nil {
+			return err
+		}
+	} else if volume.Status != "available" && volume.Status != "error" {
+		// e.g. still creating, or part-way through detaching.  A volume that fails to create can still be deleted.
+		err = waitForStatus(ctx, cinderClient, volumeID, "available", "error")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = volumes.Delete(cinderClient, volumeID, volumes.DeleteOpts{}).ExtractErr()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "deleted volume", volumeID)
+
+	return checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		v, e := volumes.Get(cinderClient, volumeID).Extract()
+		if _, notfound := e.(gophercloud.ErrDefault404); notfound {
+			return true, nil
+		}
+		if e != nil {
+			return false, e
+		}
+		if v.Status == "error_deleting" {
+			return false, fmt.Errorf("volume %s failed to delete", volumeID)
+		}
+		return false, nil
+	})
+}
+
+// waitForStatus waits until the volume reaches one of the given statuses, failing if it goes into any other error state
+func waitForStatus(ctx context.Context, cinderClient *gophercloud.ServiceClient, volumeID string, statuses ...string) error {
+	return checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		v, err := volumes.Get(cinderClient, volumeID).Extract()
+		if err != nil {
+			return false, err
+		}
+		for _, status := range statuses {
+			if v.Status == status {
+				return true, nil
+			}
+		}
+		switch v.Status {
+		case "error", "error_deleting", "error_extending", "error_restoring":
+			return false, fmt.Errorf("volume %s is in status %s", volumeID, v.Status)
+		}
+		return false, nil
+	})
+}
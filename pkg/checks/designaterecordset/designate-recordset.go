@@ -0,0 +1,366 @@
+// Package designaterecordset implements a `checker.Check` that creates a Designate recordset and waits for it to resolve
+package designaterecordset
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/recordsets"
+	"github.com/gophercloud/gophercloud/openstack/dns/v2/zones"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// queryTimeout is the maximum time for each DNS query
+const queryTimeout = 5 * time.Second
+
+// resourceRecordset is the type of resource that is created by this check
+const resourceRecordset = "recordset"
+
+type checkDesignateRecordset struct {
+	zoneName    string
+	recordName  string
+	nameservers []string
+	protocols   []string
+	autoDelete  bool
+}
+
+// New returns a new Checker instance that creates a Designate recordset and checks that it resolves
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkDesignateRecordset{
+		zoneName:   "",
+		recordName: "monitoring-test",
+		protocols:  []string{"udp"},
+		autoDelete: false,
+	}
+	if _, err := opts.String(c.GetName(), "zone_name", &c.zoneName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.ResourceName(c.GetName(), "record_name", &c.recordName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Strings(c.GetName(), "nameservers", &c.nameservers); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Strings(c.GetName(), "protocols", &c.protocols); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
+		return nil, err
+	}
+
+	if c.zoneName == "" {
+		return nil, fmt.Errorf("%s: zone_name must be set: %w", c.GetName(), checker.ErrNotConfigured)
+	}
+	if c.recordName == "" {
+		return nil, errors.New("record_name must be non-empty")
+	}
+	if len(c.nameservers) == 0 {
+		return nil, fmt.Errorf("%s: nameservers must be set: %w", c.GetName(), checker.ErrNotConfigured)
+	}
+	if len(c.protocols) == 0 {
+		return nil, errors.New("protocols must be non-empty")
+	}
+	if !strings.HasSuffix(c.zoneName, ".") {
+		c.zoneName += "."
+	}
+	for i, ns := range c.nameservers {
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			c.nameservers[i] = net.JoinHostPort(ns, "53")
+		}
+	}
+	for _, p := range c.protocols {
+		if p != "udp" && p != "tcp" {
+			return nil, fmt.Errorf("invalid protocol %q", p)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *checkDesignateRecordset) GetName() string {
+	return "designate_create_recordset"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkDesignateRecordset) ResourceNames() []string {
+	return []string{c.recordName + "." + c.zoneName}
+}
+
+func (c *checkDesignateRecordset) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	designateClient, err := openstack.NewDNSV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set designateClient.Context so we can cleanup the recordset even if the context is cancelled
+
+	// find the zone
+
+	step := checker.StartStep(ctx, "find zone")
+	zoneID, err := c.findZone(designateClient)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// check the recordset doesn't already exist
+
+	fqdn := c.recordName + "." + c.zoneName
+	step = checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(designateClient, zoneID, fqdn, output)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// create the recordset with a unique value
+
+	value, err := uniqueValue()
+	if err != nil {
+		return err
+	}
+	step = checker.StartStep(ctx, "create recordset")
+	created := time.Now()
+	owner := checker.GetOwner(ctx)
+	owner.Created = time.Time{} // the description is limited to 160 characters, and designate records created_at anyway
+	rs, err := recordsets.Create(designateClient, zoneID, recordsets.CreateOpts{
+		Name:        fqdn,
+		Type:        "TXT",
+		TTL:         60,
+		Records:     []string{`"` + value + `"`},
+		Description: owner.String(),
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceRecordset, ID: rs.ID, Name: fqdn, Created: created})
+	fmt.Fprintln(output, "created recordset", rs.ID, fqdn, "TXT", value)
+
+	// always delete the recordset, even if the context is cancelled
+
+	defer func() {
+		step := checker.StartStep(ctx, "delete recordset")
+		e := step.End(recordsets.Delete(designateClient, zoneID, rs.ID).ExtractErr())
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceRecordset, rs.ID)
+			fmt.Fprintln(output, "deleted recordset", rs.ID)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	step = checker.StartStep(ctx, "wait for ACTIVE")
+	err = step.End(checker.WaitFor(ctx, time.Second, func() (bool, error) {
+		r, e := recordsets.Get(designateClient, zoneID, rs.ID).Extract()
+		if e != nil {
+			return false, e
+		}
+		switch r.Status {
+		case "ACTIVE":
+			return true, nil
+		case "ERROR":
+			return false, fmt.Errorf("recordset %s is in status ERROR", rs.ID)
+		}
+		return false, nil
+	}))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "recordset ACTIVE after", time.Since(created).Round(time.Millisecond))
+
+	return c.waitForPropagation(ctx, fqdn, value, created, output)
+}
+
+// findZone returns the ID of the configured zone
+func (c *checkDesignateRecordset) findZone(designateClient *gophercloud.ServiceClient) (string, error) {
+	allPages, err := zones.List(designateClient, zones.ListOpts{
+		Name: c.zoneName,
+	}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	allZones, err := zones.ExtractZones(allPages)
+	if err != nil {
+		return "", err
+	}
+	if len(allZones) != 1 {
+		return "", fmt.Errorf("found %d zones named %s", len(allZones), c.zoneName)
+	}
+	return allZones[0].ID, nil
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkDesignateRecordset) ResourceTypes() []string {
+	return []string{resourceRecordset}
+}
+
+// ListResources returns the recordsets that were created by this check from this exporter instance
+func (c *checkDesignateRecordset) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	designateClient, err := openstack.NewDNSV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	designateClient.Context = ctx
+
+	zoneID, err := c.findZone(designateClient)
+	if err != nil {
+		return nil, err
+	}
+	allPages, err := recordsets.ListByZone(designateClient, zoneID, recordsets.ListOpts{
+		Name: c.recordName + "." + c.zoneName,
+		Type: "TXT",
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allRecordSets, err := recordsets.ExtractRecordSets(allPages)
+	if err != nil {
+		return nil, err
+	}
+	filter := checker.OwnerFilter(ctx)
+	var resources []checker.Resource
+	for i := range allRecordSets {
+		rs := &allRecordSets[i]
+		if owner, ok := checker.OwnerFromString(rs.Description); !ok || !owner.Matches(filter) {
+			continue
+		}
+		resources = append(resources, checker.Resource{Type: resourceRecordset, ID: rs.ID, Name: rs.Name, Created: rs.CreatedAt})
+	}
+	return resources, nil
+}
+
+// DeleteResource deletes a recordset that was created by this check
+func (c *checkDesignateRecordset) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	designateClient, err := openstack.NewDNSV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	designateClient.Context = ctx
+
+	zoneID, err := c.findZone(designateClient)
+	if err != nil {
+		return err
+	}
+	err = recordsets.Delete(designateClient, zoneID, r.ID).ExtractErr()
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	if err == nil {
+		fmt.Fprintln(output, "deleted recordset", r.ID)
+	}
+	return err
+}
+
+// deleteExisting checks whether the recordset already exists and, if auto_delete is set, deletes it
+func (c *checkDesignateRecordset) deleteExisting(designateClient *gophercloud.ServiceClient, zoneID, fqdn string, output *bytes.Buffer) error {
+	allPages, err := recordsets.ListByZone(designateClient, zoneID, recordsets.ListOpts{
+		Name: fqdn,
+		Type: "TXT",
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allRecordSets, err := recordsets.ExtractRecordSets(allPages)
+	if err != nil {
+		return err
+	}
+	if len(allRecordSets) == 0 {
+		return nil // all good, go ahead and create it
+	}
+	if !c.autoDelete {
+		return errors.New("recordset already exists")
+	}
+	for i := range allRecordSets {
+		fmt.Fprintln(output, "deleting existing recordset", allRecordSets[i].ID)
+		err = recordsets.Delete(designateClient, zoneID, allRecordSets[i].ID).ExtractErr()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForPropagation queries each nameserver in parallel until the record resolves to the expected value,
+// recording the propagation latency for each
+func (c *checkDesignateRecordset) waitForPropagation(ctx context.Context, fqdn, value string, created time.Time, output *bytes.Buffer) error {
+	var lock sync.Mutex // protects output
+	g := errgroup.Group{}
+	for _, ns := range c.nameservers {
+		for _, p := range c.protocols {
+			nameserver := ns // loop invariant
+			protocol := p    // loop invariant
+
+			g.Go(func() error {
+				step := checker.StartStep(ctx, fmt.Sprintf("resolve via %s/%s", nameserver, protocol))
+				var lastErr error
+				err := step.End(checker.WaitFor(ctx, time.Second, func() (bool, error) {
+					var records []string
+					records, lastErr = lookupTXT(ctx, nameserver, protocol, fqdn)
+					for _, r := range records {
+						if r == value {
+							return true, nil
+						}
+					}
+					return false, nil
+				}))
+
+				lock.Lock()
+				defer lock.Unlock()
+				if err != nil {
+					fmt.Fprintln(output, nameserver, protocol, "did not resolve:", lastErr)
+					return fmt.Errorf("%s/%s: %w", nameserver, protocol, err)
+				}
+				latency := time.Since(created)
+				fmt.Fprintln(output, nameserver, protocol, "resolved after", latency.Round(time.Millisecond))
+				checker.RecordMetric(ctx, checker.Metric{
+					Name: "dns_propagation_seconds",
+					Help: "Time from creating the recordset until it resolved on the nameserver",
+					Labels: map[string]string{
+						"nameserver": nameserver,
+						"protocol":   protocol,
+					},
+					Value: latency.Seconds(),
+				})
+				return nil
+			})
+		}
+	}
+	return g.Wait()
+}
+
+// lookupTXT queries the nameserver directly for the TXT records of the given name
+func lookupTXT(ctx context.Context, nameserver, protocol, name string) ([]string, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: queryTimeout}
+			return dialer.DialContext(ctx, protocol, nameserver)
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	return resolver.LookupTXT(ctx, name)
+}
+
+// uniqueValue returns a value that is unique to this run of the check
+func uniqueValue() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("openstack-check-exporter-%d-%s", time.Now().Unix(), hex.EncodeToString(b)), nil
+}
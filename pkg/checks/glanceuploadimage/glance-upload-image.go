@@ -0,0 +1,369 @@
+// Package glanceuploadimage implements a `checker.Check` that uploads/downloads/deletes a Glance image
+package glanceuploadimage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // md5 is what glance uses for the legacy checksum
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/imagedata"
+	"github.com/gophercloud/gophercloud/openstack/imageservice/v2/images"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+var (
+	// errChecksumMismatch is returned when a checksum reported by glance does not match the data that was uploaded
+	errChecksumMismatch = errors.New("image checksum does not match uploaded data")
+
+	// errDataMismatch is returned when the downloaded image does not match the data that was uploaded
+	errDataMismatch = errors.New("downloaded image does not match uploaded data")
+)
+
+// cleanupTimeout is how long we allow for deleting the image once the check context is done
+const cleanupTimeout = 2 * time.Minute
+
+// resourceImage is the type of resource that is created by this check
+const resourceImage = "image"
+
+type checkGlanceUploadImage struct {
+	imageName  string
+	imageSize  int
+	autoDelete bool
+}
+
+// New returns a new Checker instance that uploads, downloads and deletes a Glance image
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkGlanceUploadImage{
+		imageName:  "monitoring-test",
+		imageSize:  1024 * 1024,
+		autoDelete: false,
+	}
+	if _, err := opts.ResourceName(c.GetName(), "image_name", &c.imageName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Int(c.GetName(), "image_size", &c.imageSize); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
+		return nil, err
+	}
+
+	if c.imageName == "" {
+		return nil, errors.New("image_name must be non-empty")
+	}
+	if c.imageSize < 1 {
+		return nil, errors.New("image_size must be at least 1")
+	}
+
+	return c, nil
+}
+
+func (c *checkGlanceUploadImage) GetName() string {
+	return "glance_upload_image"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkGlanceUploadImage) ResourceNames() []string {
+	return []string{c.imageName}
+}
+
+func (c *checkGlanceUploadImage) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	imageClient, err := openstack.NewImageServiceV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set imageClient.Context so we can cleanup the image even if the context is cancelled
+
+	// generate some random content
+
+	data := make([]byte, c.imageSize)
+	_, err = rand.Read(data)
+	if err != nil {
+		return err
+	}
+
+	// check the image doesn't already exist
+
+	step := checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(ctx, providerClient, imageClient, output)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// create the image record
+
+	step = checker.StartStep(ctx, "create image")
+	visibility := images.ImageVisibilityPrivate
+	owner := checker.GetOwner(ctx)
+	image, err := images.Create(imageClient, images.CreateOpts{
+		Name:            c.imageName,
+		Visibility:      &visibility,
+		ContainerFormat: "bare",
+		DiskFormat:      "raw",
+		Tags:            owner.Tags(),
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceImage, ID: image.ID, Name: c.imageName, Created: owner.Created})
+	fmt.Fprintln(output, "created image", image.ID)
+
+	// always delete the image, even if the context is cancelled
+
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		step := checker.StartStep(ctx, "delete image")
+		e := step.End(deleteImage(cleanupCtx, imageClient, image.ID, output))
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceImage, image.ID)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	// upload the data
+
+	step = checker.StartStep(ctx, "upload data")
+	err = imagedata.Upload(imageClient, image.ID, bytes.NewReader(data)).ExtractErr()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "uploaded data, size", len(data))
+
+	step = checker.StartStep(ctx, "wait for active")
+	var active *images.Image
+	err = step.End(checker.WaitFor(ctx, time.Second, func() (bool, error) {
+		i, e := images.Get(imageClient, image.ID).Extract()
+		if e != nil {
+			return false, e
+		}
+		switch i.Status {
+		case images.ImageStatusActive:
+			active = i
+			return true, nil
+		case images.ImageStatusKilled, images.ImageStatusDeleted:
+			return false, fmt.Errorf("image %s is in status %s", image.ID, i.Status)
+		}
+		return false, nil
+	}))
+	if err != nil {
+		return err
+	}
+
+	// check the checksums that glance calculated
+
+	step = checker.StartStep(ctx, "verify checksums")
+	err = step.End(verifyChecksums(active, data, output))
+	if err != nil {
+		return err
+	}
+
+	// download the data and compare
+
+	step = checker.StartStep(ctx, "download data")
+	body, err := imagedata.Download(imageClient, image.ID).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	defer body.Close()
+	downloaded, err := io.ReadAll(body)
+	if err != nil {
+		return step.End(err)
+	}
+	if !bytes.Equal(data, downloaded) {
+		return step.End(errDataMismatch)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "downloaded data matches, size", len(downloaded))
+
+	return nil
+}
+
+// verifyChecksums compares the checksum and os_hash_value reported by glance against the uploaded data
+func verifyChecksums(image *images.Image, data []byte, output *bytes.Buffer) error {
+	sum := md5.Sum(data) //nolint:gosec // md5 is what glance uses for the legacy checksum
+	checksum := hex.EncodeToString(sum[:])
+	fmt.Fprintln(output, "checksum", image.Checksum, "expected", checksum)
+	if image.Checksum != checksum {
+		return fmt.Errorf("checksum: %w", errChecksumMismatch)
+	}
+
+	// os_hash_algo/os_hash_value are only present with glance multihash support (rocky onwards)
+	algo, _ := image.Properties["os_hash_algo"].(string)
+	value, _ := image.Properties["os_hash_value"].(string)
+	if algo == "" || value == "" {
+		fmt.Fprintln(output, "no os_hash_value available")
+		return nil
+	}
+	var h hash.Hash
+	switch algo {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		fmt.Fprintln(output, "unsupported os_hash_algo", algo)
+		return nil
+	}
+	_, _ = h.Write(data)
+	expected := hex.EncodeToString(h.Sum(nil))
+	fmt.Fprintln(output, "os_hash_value", algo, value, "expected", expected)
+	if value != expected {
+		return fmt.Errorf("os_hash_value: %w", errChecksumMismatch)
+	}
+	return nil
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkGlanceUploadImage) ResourceTypes() []string {
+	return []string{resourceImage}
+}
+
+// ListResources returns the images that were created by this check from this exporter instance
+func (c *checkGlanceUploadImage) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	imageClient, err := openstack.NewImageServiceV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	imageClient.Context = ctx
+
+	filter := checker.OwnerFilter(ctx)
+	allPages, err := images.List(imageClient, images.ListOpts{
+		Name: c.imageName,
+		Tags: filter.Tags(),
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allImages, err := images.ExtractImages(allPages)
+	if err != nil {
+		return nil, err
+	}
+	var resources []checker.Resource
+	for i := range allImages {
+		image := &allImages[i]
+		owner, ok := checker.OwnerFromTags(image.Tags)
+		if !ok || !owner.Matches(filter) {
+			continue // in case the server ignored the filter
+		}
+		resources = append(resources, checker.Resource{Type: resourceImage, ID: image.ID, Name: image.Name, Created: owner.Created})
+	}
+	return resources, nil
+}
+
+// DeleteResource deletes an image that was created by this check
+func (c *checkGlanceUploadImage) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	imageClient, err := openstack.NewImageServiceV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	err = deleteImage(ctx, imageClient, r.ID, output)
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	return err
+}
+
+// deleteExisting checks whether the image already exists and, if auto_delete is set, deletes it.  Only images
+// in our own project that were created by this check from this exporter instance are considered, since e.g. admin
+// credentials can see the images of other projects.
+func (c *checkGlanceUploadImage) deleteExisting(ctx context.Context, providerClient *gophercloud.ProviderClient,
+	imageClient *gophercloud.ServiceClient, output *bytes.Buffer) error {
+
+	projectID, err := getProjectID(providerClient)
+	if err != nil {
+		return err
+	}
+	filter := checker.OwnerFilter(ctx)
+	allPages, err := images.List(imageClient, images.ListOpts{
+		Name:  c.imageName,
+		Owner: projectID,
+		Tags:  filter.Tags(),
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	all, err := images.ExtractImages(allPages)
+	if err != nil {
+		return err
+	}
+	var allImages []images.Image
+	for i := range all {
+		owner, ok := checker.OwnerFromTags(all[i].Tags)
+		if all[i].Owner == projectID && ok && owner.Matches(filter) {
+			allImages = append(allImages, all[i])
+		}
+	}
+	switch len(allImages) {
+	case 0: // all good, go ahead and create it
+
+	case 1:
+		if !c.autoDelete {
+			return errors.New("image already exists")
+		}
+
+		// delete the existing image
+		imageID := allImages[0].ID
+		fmt.Fprintln(output, "deleting existing image", imageID)
+		return deleteImage(ctx, imageClient, imageID, output)
+
+	default:
+		return errors.New("found multiple images")
+	}
+
+	return nil
+}
+
+// getProjectID returns the ID of the project that the client is authenticated against
+func getProjectID(providerClient *gophercloud.ProviderClient) (string, error) {
+	result, ok := providerClient.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return "", errors.New("no keystone v3 auth result available")
+	}
+	project, err := result.ExtractProject()
+	if err != nil {
+		return "", err
+	}
+	if project == nil {
+		return "", errors.New("token is not project-scoped")
+	}
+	return project.ID, nil
+}
+
+// deleteImage deletes the image and waits for it to be gone
+func deleteImage(ctx context.Context, imageClient *gophercloud.ServiceClient, imageID string, output *bytes.Buffer) error {
+	err := images.Delete(imageClient, imageID).ExtractErr()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "deleting image", imageID)
+
+	return checker.WaitFor(ctx, time.Second, func() (bool, error) {
+		i, e := images.Get(imageClient, imageID).Extract()
+		if _, notfound := e.(gophercloud.ErrDefault404); notfound {
+			return true, nil
+		}
+		if e != nil {
+			return false, e
+		}
+		return i.Status == images.ImageStatusDeleted, nil
+	})
+}
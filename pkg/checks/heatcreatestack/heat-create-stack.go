@@ -0,0 +1,281 @@
+// Package heatcreatestack implements a `checker.Check` that creates/deletes a Heat stack
+package heatcreatestack
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/orchestration/v1/stackevents"
+	"github.com/gophercloud/gophercloud/openstack/orchestration/v1/stacks"
+	"github.com/gophercloud/gophercloud/pagination"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+var (
+	//go:embed template.yaml
+	defaultTemplate []byte
+)
+
+// cleanupTimeout is how long we allow for deleting the stack once the check context is done
+const cleanupTimeout = 5 * time.Minute
+
+// resourceStack is the type of resource that is created by this check
+const resourceStack = "stack"
+
+type checkHeatStack struct {
+	stackName  string
+	template   []byte
+	autoDelete bool
+}
+
+// New returns a new Checker instance that creates and deletes a Heat stack
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkHeatStack{
+		stackName:  "monitoring-test",
+		template:   defaultTemplate,
+		autoDelete: false,
+	}
+	templatePath := ""
+	if _, err := opts.ResourceName(c.GetName(), "stack_name", &c.stackName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "template_path", &templatePath); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
+		return nil, err
+	}
+
+	if c.stackName == "" {
+		return nil, errors.New("stack_name must be non-empty")
+	}
+	if templatePath != "" {
+		b, err := os.ReadFile(filepath.Clean(templatePath))
+		if err != nil {
+			return nil, err
+		}
+		c.template = b
+	}
+
+	return c, nil
+}
+
+func (c *checkHeatStack) GetName() string {
+	return "heat_create_stack"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkHeatStack) ResourceNames() []string {
+	return []string{c.stackName}
+}
+
+func (c *checkHeatStack) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	heatClient, err := openstack.NewOrchestrationV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set heatClient.Context so we can cleanup the stack even if the context is cancelled
+
+	// check the stack doesn't already exist
+
+	step := checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(ctx, heatClient, output)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// create the stack
+
+	step = checker.StartStep(ctx, "create stack")
+	owner := checker.GetOwner(ctx)
+	stack, err := stacks.Create(heatClient, stacks.CreateOpts{
+		Name: c.stackName,
+		TemplateOpts: &stacks.Template{
+			TE: stacks.TE{
+				Bin: c.template,
+			},
+		},
+		Tags: owner.Tags(),
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceStack, ID: stack.ID, Name: c.stackName, Created: owner.Created})
+	fmt.Fprintln(output, "created stack", stack.ID)
+
+	// always delete the stack, even if the context is cancelled
+
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		step := checker.StartStep(ctx, "delete stack")
+		e := step.End(deleteStack(cleanupCtx, heatClient, c.stackName, stack.ID, output))
+		if e != nil {
+			showEvents(heatClient, c.stackName, stack.ID, output)
+		} else {
+			checker.ReleaseResource(ctx, resourceStack, stack.ID)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	step = checker.StartStep(ctx, "wait for CREATE_COMPLETE")
+	err = step.End(checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		s, e := stacks.Get(heatClient, c.stackName, stack.ID).Extract()
+		if e != nil {
+			return false, e
+		}
+		switch s.Status {
+		case "CREATE_COMPLETE":
+			return true, nil
+		case "CREATE_FAILED":
+			return false, fmt.Errorf("stack %s is in status %s: %s", stack.ID, s.Status, s.StatusReason)
+		}
+		return false, nil
+	}))
+	if err != nil && !errors.Is(err, checker.ErrCancelled) {
+		showEvents(heatClient, c.stackName, stack.ID, output)
+	}
+
+	return err
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkHeatStack) ResourceTypes() []string {
+	return []string{resourceStack}
+}
+
+// ListResources returns the stacks that were created by this check from this exporter instance
+func (c *checkHeatStack) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	heatClient, err := openstack.NewOrchestrationV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	heatClient.Context = ctx
+
+	filter := checker.OwnerFilter(ctx)
+	allPages, err := stacks.List(heatClient, stacks.ListOpts{
+		Name: c.stackName,
+		Tags: strings.Join(filter.Tags(), ","),
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allStacks, err := stacks.ExtractStacks(allPages)
+	if err != nil {
+		return nil, err
+	}
+	var resources []checker.Resource
+	for i := range allStacks {
+		s := &allStacks[i]
+		owner, ok := checker.OwnerFromTags(s.Tags)
+		if !ok || !owner.Matches(filter) {
+			continue // in case the server ignored the filter
+		}
+		resources = append(resources, checker.Resource{Type: resourceStack, ID: s.ID, Name: s.Name, Created: owner.Created})
+	}
+	return resources, nil
+}
+
+// DeleteResource deletes a stack that was created by this check
+func (c *checkHeatStack) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	heatClient, err := openstack.NewOrchestrationV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	err = deleteStack(ctx, heatClient, c.stackName, r.ID, output)
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	return err
+}
+
+// deleteExisting checks whether the stack already exists and, if auto_delete is set, deletes it
+func (c *checkHeatStack) deleteExisting(ctx context.Context, heatClient *gophercloud.ServiceClient, output *bytes.Buffer) error {
+	allPages, err := stacks.List(heatClient, stacks.ListOpts{
+		Name: c.stackName,
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allStacks, err := stacks.ExtractStacks(allPages)
+	if err != nil {
+		return err
+	}
+	switch len(allStacks) {
+	case 0: // all good, go ahead and create it
+
+	case 1:
+		if !c.autoDelete {
+			return errors.New("stack already exists")
+		}
+
+		// delete the existing stack
+		stackID := allStacks[0].ID
+		fmt.Fprintln(output, "deleting existing stack", stackID)
+		return deleteStack(ctx, heatClient, c.stackName, stackID, output)
+
+	default:
+		return errors.New("found multiple stacks")
+	}
+
+	return nil
+}
+
+// deleteStack deletes the stack and waits for it to be gone
+func deleteStack(ctx context.Context, heatClient *gophercloud.ServiceClient, stackName, stackID string, output *bytes.Buffer) error {
+	err := stacks.Delete(heatClient, stackName, stackID).ExtractErr()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "deleting stack", stackID)
+
+	return checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		s, e := stacks.Get(heatClient, stackName, stackID).Extract()
+		if _, notfound := e.(gophercloud.ErrDefault404); notfound {
+			return true, nil
+		}
+		if e != nil {
+			return false, e
+		}
+		switch s.Status {
+		case "DELETE_COMPLETE":
+			return true, nil
+		case "DELETE_FAILED":
+			return false, fmt.Errorf("stack %s is in status %s: %s", stackID, s.Status, s.StatusReason)
+		}
+		return false, nil
+	})
+}
+
+// showEvents writes the stack events to the output, to help diagnose failures
+func showEvents(heatClient *gophercloud.ServiceClient, stackName, stackID string, output *bytes.Buffer) {
+	fmt.Fprintln(output, "stack events:")
+	err := stackevents.List(heatClient, stackName, stackID, stackevents.ListOpts{}).EachPage(func(page pagination.Page) (bool, error) {
+		events, e := stackevents.ExtractEvents(page)
+		if e != nil {
+			return false, e
+		}
+		for i := range events {
+			event := &events[i]
+			fmt.Fprintln(output, " ", event.Time.UTC().Format(time.RFC3339), event.ResourceName, event.ResourceStatus, event.ResourceStatusReason)
+		}
+		return true, nil
+	})
+	if err != nil {
+		fmt.Fprintln(output, "unable to list stack events:", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Package keystonetoken implements a `checker.Check` that issues/validates/revokes a keystone token
+package keystonetoken
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+type checkKeystoneToken struct {
+	authOpts gophercloud.AuthOptions
+}
+
+// New returns a new Checker instance that issues, validates and revokes a keystone token
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkKeystoneToken{
+		authOpts: *authOpts,
+	}
+	c.authOpts.AllowReauth = false
+	return c, nil
+}
+
+func (c *checkKeystoneToken) GetName() string {
+	return "keystone_token"
+}
+
+func (c *checkKeystoneToken) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	identityClient, err := openstack.NewIdentityV3(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set identityClient.Context so we can revoke the token even if the context is cancelled
+
+	// issue a new token
+
+	step := checker.StartStep(ctx, "issue token")
+	create := tokens.Create(identityClient, &c.authOpts)
+	token, err := create.ExtractToken()
+	if err != nil {
+		return step.End(err)
+	}
+	tokenID, err := create.ExtractTokenID()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "issued token, expires", token.ExpiresAt.UTC())
+
+	revoked := false
+	defer func() {
+		if revoked {
+			return
+		}
+		step := checker.StartStep(ctx, "revoke token")
+		e := step.End(tokens.Revoke(identityClient, tokenID).Err)
+		if err == nil {
+			err = e
+		}
+	}()
+
+	// validate it and list the catalog
+
+	step = checker.StartStep(ctx, "validate token")
+	get := tokens.Get(identityClient, tokenID)
+	catalog, err := get.ExtractServiceCatalog()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	if len(catalog.Entries) == 0 {
+		return errors.New("service catalog is empty")
+	}
+	for i := range catalog.Entries {
+		e := &catalog.Entries[i]
+		fmt.Fprintln(output, "catalog", e.Type, e.Name, len(e.Endpoints), "endpoints")
+	}
+
+	if ctx.Err() != nil {
+		return checker.ErrCancelled
+	}
+
+	// revoke it and confirm that it is no longer valid
+
+	step = checker.StartStep(ctx, "revoke token")
+	err = tokens.Revoke(identityClient, tokenID).Err
+	revoked = true
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "revoked token")
+
+	step = checker.StartStep(ctx, "validate revoked token")
+	valid, err := tokens.Validate(identityClient, tokenID)
+	if err != nil {
+		return step.End(err)
+	}
+	if valid {
+		return step.End(errors.New("token is still valid after revocation"))
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "revoked token is no longer valid")
+
+	return nil
+}
@@ -0,0 +1,393 @@
+// Package manilacreateshare implements a `checker.Check` that creates/deletes a Manila share
+package manilacreateshare
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/sharenetworks"
+	"github.com/gophercloud/gophercloud/openstack/sharedfilesystems/v2/shares"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// cleanupTimeout is how long we allow for deleting the share once the check context is done
+const cleanupTimeout = 5 * time.Minute
+
+// microversion is the minimum manila API microversion that supports the access rule calls
+const microversion = "2.7"
+
+// resourceShare is the type of resource that is created by this check
+const resourceShare = "share"
+
+type checkManilaShare struct {
+	shareName        string
+	shareType        string
+	shareNetworkName string
+	shareProto       string
+	shareSize        int
+	accessType       string
+	accessTo         string
+	autoDelete       bool
+}
+
+// New returns a new Checker instance that creates and deletes a Manila share
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkManilaShare{
+		shareName:        "monitoring-test",
+		shareType:        "",
+		shareNetworkName: "", // not needed if the share type has driver_handles_share_servers=false
+		shareProto:       "NFS",
+		shareSize:        1,
+		accessType:       "ip",
+		accessTo:         "192.0.2.1",
+		autoDelete:       false,
+	}
+	if _, err := opts.ResourceName(c.GetName(), "share_name", &c.shareName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "share_type", &c.shareType); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "share_network_name", &c.shareNetworkName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "share_proto", &c.shareProto); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Int(c.GetName(), "share_size", &c.shareSize); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "access_type", &c.accessType); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "access_to", &c.accessTo); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
+		return nil, err
+	}
+
+	if c.shareType == "" {
+		return nil, fmt.Errorf("%s: share_type must be set: %w", c.GetName(), checker.ErrNotConfigured)
+	}
+	if c.shareName == "" {
+		return nil, errors.New("share_name must be non-empty")
+	}
+	if c.shareSize < 1 {
+		return nil, errors.New("share_size must be at least 1")
+	}
+	if c.accessType == "" || c.accessTo == "" {
+		return nil, errors.New("access_type and access_to must be non-empty")
+	}
+
+	return c, nil
+}
+
+func (c *checkManilaShare) GetName() string {
+	return "manila_create_share"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkManilaShare) ResourceNames() []string {
+	return []string{c.shareName}
+}
+
+func (c *checkManilaShare) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	manilaClient, err := openstack.NewSharedFileSystemV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	manilaClient.Microversion = microversion
+	// don't set manilaClient.Context so we can cleanup the share even if the context is cancelled
+
+	// resolve names into IDs
+
+	shareNetworkID := ""
+	if c.shareNetworkName != "" {
+		step := checker.StartStep(ctx, "resolve names")
+		shareNetworkID, err = c.findShareNetwork(manilaClient)
+		if err != nil {
+			return step.End(err)
+		}
+		step.End(nil)
+	}
+
+	// check the share doesn't already exist
+
+	step := checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(ctx, manilaClient, output)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// create the share
+
+	step = checker.StartStep(ctx, "create share")
+	owner := checker.GetOwner(ctx)
+	share, err := shares.Create(manilaClient, shares.CreateOpts{
+		Name:           c.shareName,
+		ShareProto:     c.shareProto,
+		Size:           c.shareSize,
+		ShareType:      c.shareType,
+		ShareNetworkID: shareNetworkID,
+		Metadata:       owner.Metadata(),
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceShare, ID: share.ID, Name: c.shareName, Created: owner.Created})
+	fmt.Fprintln(output, "created share", share.ID)
+
+	// always delete the share, even if the context is cancelled
+
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		step := checker.StartStep(ctx, "delete share")
+		e := step.End(deleteShare(cleanupCtx, manilaClient, share.ID, output))
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceShare, share.ID)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	step = checker.StartStep(ctx, "wait for available")
+	err = step.End(checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		s, e := shares.Get(manilaClient, share.ID).Extract()
+		if e != nil {
+			return false, e
+		}
+		switch s.Status {
+		case "available":
+			return true, nil
+		case "error":
+			return false, fmt.Errorf("share %s is in status %s", share.ID, s.Status)
+		}
+		return false, nil
+	}))
+	if err != nil {
+		return err
+	}
+
+	return c.checkAccessRule(ctx, manilaClient, share.ID, output)
+}
+
+// checkAccessRule grants access to the share, waits for the rule to become active, then revokes it again
+func (c *checkManilaShare) checkAccessRule(ctx context.Context, manilaClient *gophercloud.ServiceClient, shareID string, output *bytes.Buffer) error {
+	step := checker.StartStep(ctx, "grant access")
+	rule, err := shares.GrantAccess(manilaClient, shareID, shares.GrantAccessOpts{
+		AccessType:  c.accessType,
+		AccessTo:    c.accessTo,
+		AccessLevel: "ro",
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "granted access", rule.ID, c.accessType, c.accessTo)
+
+	step = checker.StartStep(ctx, "wait for access rule active")
+	err = step.End(checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		r, e := findAccessRule(manilaClient, shareID, rule.ID)
+		if e != nil {
+			return false, e
+		}
+		if r == nil {
+			return false, fmt.Errorf("access rule %s not found", rule.ID)
+		}
+		switch r.State {
+		case "active":
+			return true, nil
+		case "error":
+			return false, fmt.Errorf("access rule %s is in state %s", rule.ID, r.State)
+		}
+		return false, nil
+	}))
+	if err != nil {
+		return err
+	}
+
+	step = checker.StartStep(ctx, "revoke access")
+	err = shares.RevokeAccess(manilaClient, shareID, shares.RevokeAccessOpts{
+		AccessID: rule.ID,
+	}).ExtractErr()
+	if err != nil {
+		return step.End(err)
+	}
+	err = checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		r, e := findAccessRule(manilaClient, shareID, rule.ID)
+		if e != nil {
+			return false, e
+		}
+		if r != nil && r.State == "error" {
+			return false, fmt.Errorf("access rule %s is in state %s", rule.ID, r.State)
+		}
+		return r == nil, nil
+	})
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "revoked access", rule.ID)
+
+	return nil
+}
+
+// findShareNetwork returns the ID of the configured share network
+func (c *checkManilaShare) findShareNetwork(manilaClient *gophercloud.ServiceClient) (string, error) {
+	allPages, err := sharenetworks.ListDetail(manilaClient, sharenetworks.ListOpts{
+		Name: c.shareNetworkName,
+	}).AllPages()
+	if err != nil {
+		return "", err
+	}
+	allShareNetworks, err := sharenetworks.ExtractShareNetworks(allPages)
+	if err != nil {
+		return "", err
+	}
+	if len(allShareNetworks) != 1 {
+		return "", fmt.Errorf("found %d share networks named %s", len(allShareNetworks), c.shareNetworkName)
+	}
+	return allShareNetworks[0].ID, nil
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkManilaShare) ResourceTypes() []string {
+	return []string{resourceShare}
+}
+
+// ListResources returns the shares that were created by this check from this exporter instance
+func (c *checkManilaShare) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	manilaClient, err := openstack.NewSharedFileSystemV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	manilaClient.Context = ctx
+
+	filter := checker.OwnerFilter(ctx)
+	allPages, err := shares.ListDetail(manilaClient, shares.ListOpts{
+		Name:     c.shareName,
+		Metadata: filter.Metadata(),
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allShares, err := shares.ExtractShares(allPages)
+	if err != nil {
+		return nil, err
+	}
+	var resources []checker.Resource
+	for i := range allShares {
+		s := &allShares[i]
+		owner, ok := checker.OwnerFromMetadata(s.Metadata)
+		if !ok || !owner.Matches(filter) {
+			continue // in case the server ignored the filter
+		}
+		resources = append(resources, checker.Resource{Type: resourceShare, ID: s.ID, Name: s.Name, Created: owner.Created})
+	}
+	return resources, nil
+}
+
+// DeleteResource deletes a share that was created by this check
+func (c *checkManilaShare) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	manilaClient, err := openstack.NewSharedFileSystemV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	err = deleteShare(ctx, manilaClient, r.ID, output)
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	return err
+}
+
+// deleteExisting checks whether the share already exists and, if auto_delete is set, deletes it
+func (c *checkManilaShare) deleteExisting(ctx context.Context, manilaClient *gophercloud.ServiceClient, output *bytes.Buffer) error {
+	allPages, err := shares.ListDetail(manilaClient, shares.ListOpts{
+		Name: c.shareName,
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allShares, err := shares.ExtractShares(allPages)
+	if err != nil {
+		return err
+	}
+	switch len(allShares) {
+	case 0: // all good, go ahead and create it
+
+	case 1:
+		if !c.autoDelete {
+			return errors.New("share already exists")
+		}
+
+		// delete the existing share
+		shareID := allShares[0].ID
+		fmt.Fprintln(output, "deleting existing share", shareID)
+		return deleteShare(ctx, manilaClient, shareID, output)
+
+	default:
+		return errors.New("found multiple shares")
+	}
+
+	return nil
+}
+
+// findAccessRule returns the access rule with the given ID, or nil if it does not exist
+func findAccessRule(manilaClient *gophercloud.ServiceClient, shareID, ruleID string) (*shares.AccessRight, error) {
+	rules, err := shares.ListAccessRights(manilaClient, shareID).Extract()
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if rules[i].ID == ruleID {
+			return &rules[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// deleteShare deletes the share and waits for it to be gone.  Manila won't delete a share that is still being
+// created, so first wait for it to become available or fail.
+func deleteShare(ctx context.Context, manilaClient *gophercloud.ServiceClient, shareID string, output *bytes.Buffer) error {
+	err := checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		s, e := shares.Get(manilaClient, shareID).Extract()
+		if e != nil {
+			return false, e
+		}
+		return s.Status != "creating", nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = shares.Delete(manilaClient, shareID).ExtractErr()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "deleting share", shareID)
+
+	return checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		s, e := shares.Get(manilaClient, shareID).Extract()
+		if _, notfound := e.(gophercloud.ErrDefault404); notfound {
+			return true, nil
+		}
+		if e != nil {
+			return false, e
+		}
+		if s.Status == "error_deleting" {
+			return false, fmt.Errorf("share %s is in status %s", shareID, s.Status)
+		}
+		return false, nil
+	})
+}
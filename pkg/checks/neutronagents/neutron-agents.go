@@ -0,0 +1,103 @@
+// Package neutronagents implements a `checker.Check` that lists neutron agents and checks their state
+package neutronagents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/agents"
+	"github.com/gophercloud/gophercloud/pagination"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+type checkNeutronAgents struct {
+	ignoreAgentTypes map[string]bool
+	ignoreHosts      map[string]bool
+}
+
+// New returns a new Checker instance that lists neutron agents
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkNeutronAgents{}
+	var ignoreAgentTypes, ignoreHosts []string
+	if _, err := opts.Strings(c.GetName(), "ignore_agent_types", &ignoreAgentTypes); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Strings(c.GetName(), "ignore_hosts", &ignoreHosts); err != nil {
+		return nil, err
+	}
+	c.ignoreAgentTypes = toSet(ignoreAgentTypes)
+	c.ignoreHosts = toSet(ignoreHosts)
+	return c, nil
+}
+
+func (c *checkNeutronAgents) GetName() string {
+	return "neutron_check_agents"
+}
+
+func (c *checkNeutronAgents) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) error {
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	neutronClient.Context = ctx
+
+	healthy := true
+	count := 0
+	err = agents.List(neutronClient, agents.ListOpts{}).EachPage(func(page pagination.Page) (bool, error) {
+		agentList, e := agents.ExtractAgents(page)
+		if e != nil {
+			healthy = false
+			return false, e
+		}
+		for i := range agentList {
+			a := &agentList[i]
+			heartbeatAge := time.Since(a.HeartbeatTimestamp).Round(time.Second)
+			ignored := c.ignoreAgentTypes[a.AgentType] || c.ignoreHosts[a.Host]
+			state := "up"
+			if !a.Alive {
+				state = "down"
+			}
+			status := "enabled"
+			if !a.AdminStateUp {
+				status = "disabled"
+			}
+			if ignored {
+				status += " (ignored)"
+			} else if a.AdminStateUp && !a.Alive {
+				healthy = false
+			}
+			fmt.Fprintln(output, a.AgentType, a.AvailabilityZone, a.Host, state, status, "heartbeat", heartbeatAge, "ago")
+		}
+		count += len(agentList)
+		return true, nil // true: we want to check all agents
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if !healthy {
+		return errors.New("neutron agents not healthy")
+	}
+
+	if count == 0 {
+		return errors.New("no neutron agents found")
+	}
+
+	return nil
+}
+
+// toSet converts a list of strings into a map for fast lookup
+func toSet(list []string) map[string]bool {
+	m := make(map[string]bool, len(list))
+	for _, s := range list {
+		m[s] = true
+	}
+	return m
+}
@@ -0,0 +1,510 @@
+// Package neutroncreatenetwork implements a `checker.Check` that creates/deletes a neutron network, subnet, router and port
+package neutroncreatenetwork
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+	utilsnetworks "github.com/gophercloud/utils/openstack/networking/v2/networks"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// resource types that are created by this check.  Subnets and ports are deleted along with the network.
+const (
+	resourceNetwork = "network"
+	resourceRouter  = "router"
+)
+
+type checkNeutronCreateNetwork struct {
+	name                string
+	cidr                string
+	externalNetworkName string
+	autoDelete          bool
+}
+
+// New returns a new Checker instance that creates and deletes a neutron network, subnet, router and port
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkNeutronCreateNetwork{
+		name:                "monitoring-test",
+		cidr:                "192.168.199.0/24",
+		externalNetworkName: "public",
+		autoDelete:          false,
+	}
+	if _, err := opts.ResourceName(c.GetName(), "name", &c.name); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "cidr", &c.cidr); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "external_network_name", &c.externalNetworkName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
+		return nil, err
+	}
+
+	if c.name == "" {
+		return nil, errors.New("name must be non-empty")
+	}
+	if c.cidr == "" {
+		return nil, errors.New("cidr must be non-empty")
+	}
+
+	return c, nil
+}
+
+func (c *checkNeutronCreateNetwork) GetName() string {
+	return "neutron_create_network"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkNeutronCreateNetwork) ResourceNames() []string {
+	return []string{c.name}
+}
+
+func (c *checkNeutronCreateNetwork) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set neutronClient.Context so we can cleanup even if the context is cancelled
+
+	// resolve names into IDs
+
+	externalNetworkID := ""
+	if c.externalNetworkName != "" {
+		step := checker.StartStep(ctx, "resolve names")
+		externalNetworkID, err = utilsnetworks.IDFromName(neutronClient, c.externalNetworkName)
+		if err != nil {
+			return step.End(err)
+		}
+		step.End(nil)
+	}
+
+	// check the resources don't already exist
+
+	step := checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(neutronClient, output)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// create everything, always tearing down whatever was created even if the context is cancelled
+
+	var td teardown
+	defer func() {
+		e := td.run(ctx, output)
+		if err == nil {
+			err = e
+		}
+	}()
+
+	return c.create(ctx, neutronClient, externalNetworkID, &td, output)
+}
+
+// create creates the network, subnet, router, router interface and port, registering each with the teardown
+func (c *checkNeutronCreateNetwork) create(ctx context.Context, neutronClient *gophercloud.ServiceClient, externalNetworkID string, td *teardown, output *bytes.Buffer) error {
+
+	// network
+
+	step := checker.StartStep(ctx, "create network")
+	owner := checker.GetOwner(ctx)
+	network, err := networks.Create(neutronClient, networks.CreateOpts{
+		Name: c.name,
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceNetwork, ID: network.ID, Name: c.name, Created: owner.Created})
+	fmt.Fprintln(output, "created network", network.ID)
+	td.add("delete network", func() error {
+		e := networks.Delete(neutronClient, network.ID).ExtractErr()
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceNetwork, network.ID)
+		}
+		return e
+	})
+
+	step = checker.StartStep(ctx, "tag network")
+	err = step.End(tag(neutronClient, "networks", network.ID, owner))
+	if err != nil {
+		return err
+	}
+
+	step = checker.StartStep(ctx, "wait for network ACTIVE")
+	err = step.End(waitForStatus(ctx, "network", "ACTIVE", func() (string, error) {
+		n, e := networks.Get(neutronClient, network.ID).Extract()
+		if e != nil {
+			return "", e
+		}
+		return n.Status, nil
+	}))
+	if err != nil {
+		return err
+	}
+
+	// subnet
+
+	step = checker.StartStep(ctx, "create subnet")
+	subnet, err := subnets.Create(neutronClient, subnets.CreateOpts{
+		Name:      c.name,
+		NetworkID: network.ID,
+		CIDR:      c.cidr,
+		IPVersion: gophercloud.IPv4,
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "created subnet", subnet.ID, subnet.CIDR)
+	td.add("delete subnet", func() error {
+		return subnets.Delete(neutronClient, subnet.ID).ExtractErr()
+	})
+
+	// router
+
+	err = c.createRouter(ctx, neutronClient, externalNetworkID, subnet.ID, td, output)
+	if err != nil {
+		return err
+	}
+
+	// port
+
+	step = checker.StartStep(ctx, "create port")
+	port, err := ports.Create(neutronClient, ports.CreateOpts{
+		Name:      c.name,
+		NetworkID: network.ID,
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "created port", port.ID)
+	td.add("delete port", func() error {
+		return ports.Delete(neutronClient, port.ID).ExtractErr()
+	})
+
+	// the port is not bound to anything, so it should be DOWN
+	step = checker.StartStep(ctx, "wait for port DOWN")
+	return step.End(waitForStatus(ctx, "port", "DOWN", func() (string, error) {
+		p, e := ports.Get(neutronClient, port.ID).Extract()
+		if e != nil {
+			return "", e
+		}
+		return p.Status, nil
+	}))
+}
+
+// createRouter creates the router with its external gateway and adds an interface on the subnet
+func (c *checkNeutronCreateNetwork) createRouter(ctx context.Context, neutronClient *gophercloud.ServiceClient, externalNetworkID, subnetID string,
+	td *teardown, output *bytes.Buffer) error {
+
+	createOpts := routers.CreateOpts{
+		Name: c.name,
+	}
+	if externalNetworkID != "" {
+		createOpts.GatewayInfo = &routers.GatewayInfo{
+			NetworkID: externalNetworkID,
+		}
+	}
+
+	step := checker.StartStep(ctx, "create router")
+	owner := checker.GetOwner(ctx)
+	router, err := routers.Create(neutronClient, createOpts).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceRouter, ID: router.ID, Name: c.name, Created: owner.Created})
+	fmt.Fprintln(output, "created router", router.ID)
+	td.add("delete router", func() error {
+		e := routers.Delete(neutronClient, router.ID).ExtractErr()
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceRouter, router.ID)
+		}
+		return e
+	})
+
+	step = checker.StartStep(ctx, "tag router")
+	err = step.End(tag(neutronClient, "routers", router.ID, owner))
+	if err != nil {
+		return err
+	}
+
+	step = checker.StartStep(ctx, "wait for router ACTIVE")
+	err = step.End(waitForStatus(ctx, "router", "ACTIVE", func() (string, error) {
+		r, e := routers.Get(neutronClient, router.ID).Extract()
+		if e != nil {
+			return "", e
+		}
+		return r.Status, nil
+	}))
+	if err != nil {
+		return err
+	}
+
+	step = checker.StartStep(ctx, "add router interface")
+	iface, err := routers.AddInterface(neutronClient, router.ID, routers.AddInterfaceOpts{
+		SubnetID: subnetID,
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "added router interface", iface.PortID)
+	td.add("remove router interface", func() error {
+		_, e := routers.RemoveInterface(neutronClient, router.ID, routers.RemoveInterfaceOpts{
+			SubnetID: subnetID,
+		}).Extract()
+		return e
+	})
+
+	return nil
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkNeutronCreateNetwork) ResourceTypes() []string {
+	return []string{resourceRouter, resourceNetwork}
+}
+
+// ListResources returns the routers and networks that were created by this check from this exporter instance.
+// Routers are listed first, since they must be deleted before the networks.
+func (c *checkNeutronCreateNetwork) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	neutronClient.Context = ctx
+
+	filter := checker.OwnerFilter(ctx)
+	tags := strings.Join(filter.Tags(), ",")
+	allPages, err := routers.List(neutronClient, routers.ListOpts{
+		Name: c.name,
+		Tags: tags,
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allRouters, err := routers.ExtractRouters(allPages)
+	if err != nil {
+		return nil, err
+	}
+
+	allPages, err = networks.List(neutronClient, networks.ListOpts{
+		Name: c.name,
+		Tags: tags,
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allNetworks, err := networks.ExtractNetworks(allPages)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []checker.Resource
+	for i := range allRouters {
+		r := &allRouters[i]
+		owner, ok := checker.OwnerFromTags(r.Tags)
+		if !ok || !owner.Matches(filter) {
+			continue // in case the server ignored the filter
+		}
+		resources = append(resources, checker.Resource{Type: resourceRouter, ID: r.ID, Name: r.Name, Created: owner.Created})
+	}
+	for i := range allNetworks {
+		n := &allNetworks[i]
+		owner, ok := checker.OwnerFromTags(n.Tags)
+		if !ok || !owner.Matches(filter) {
+			continue // in case the server ignored the filter
+		}
+		resources = append(resources, checker.Resource{Type: resourceNetwork, ID: n.ID, Name: n.Name, Created: owner.Created})
+	}
+	return resources, nil
+}
+
+// DeleteResource deletes a router or network that was created by this check
+func (c *checkNeutronCreateNetwork) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	switch r.Type {
+	case resourceRouter:
+		err = deleteRouter(neutronClient, r.ID)
+	case resourceNetwork:
+		err = deleteNetwork(neutronClient, r.ID)
+	}
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	if err == nil {
+		fmt.Fprintln(output, "deleted", r.Type, r.ID)
+	}
+	return err
+}
+
+// deleteExisting checks whether resources from a previous run still exist and, if auto_delete is set, deletes them
+func (c *checkNeutronCreateNetwork) deleteExisting(neutronClient *gophercloud.ServiceClient, output *bytes.Buffer) error {
+	allPages, err := routers.List(neutronClient, routers.ListOpts{
+		Name: c.name,
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allRouters, err := routers.ExtractRouters(allPages)
+	if err != nil {
+		return err
+	}
+
+	allPages, err = networks.List(neutronClient, networks.ListOpts{
+		Name: c.name,
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allNetworks, err := networks.ExtractNetworks(allPages)
+	if err != nil {
+		return err
+	}
+
+	if len(allRouters) == 0 && len(allNetworks) == 0 {
+		return nil // all good, go ahead and create them
+	}
+	if !c.autoDelete {
+		return errors.New("network or router already exists")
+	}
+
+	for i := range allRouters {
+		fmt.Fprintln(output, "deleting existing router", allRouters[i].ID)
+		err = deleteRouter(neutronClient, allRouters[i].ID)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range allNetworks {
+		fmt.Fprintln(output, "deleting existing network", allNetworks[i].ID)
+		err = deleteNetwork(neutronClient, allNetworks[i].ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRouter removes all interfaces from the router, then deletes it
+func deleteRouter(neutronClient *gophercloud.ServiceClient, routerID string) error {
+	allPages, err := ports.List(neutronClient, ports.ListOpts{
+		DeviceID:    routerID,
+		DeviceOwner: "network:router_interface",
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allPorts, err := ports.ExtractPorts(allPages)
+	if err != nil {
+		return err
+	}
+	for i := range allPorts {
+		_, err = routers.RemoveInterface(neutronClient, routerID, routers.RemoveInterfaceOpts{
+			PortID: allPorts[i].ID,
+		}).Extract()
+		if err != nil {
+			return err
+		}
+	}
+	return routers.Delete(neutronClient, routerID).ExtractErr()
+}
+
+// deleteNetwork deletes any unbound ports from the network, then deletes it along with its subnets
+func deleteNetwork(neutronClient *gophercloud.ServiceClient, networkID string) error {
+	allPages, err := ports.List(neutronClient, ports.ListOpts{
+		NetworkID: networkID,
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allPorts, err := ports.ExtractPorts(allPages)
+	if err != nil {
+		return err
+	}
+	for i := range allPorts {
+		if allPorts[i].DeviceOwner != "" {
+			continue // e.g. DHCP ports are deleted along with the network
+		}
+		err = ports.Delete(neutronClient, allPorts[i].ID).ExtractErr()
+		if err != nil {
+			return err
+		}
+	}
+	return networks.Delete(neutronClient, networkID).ExtractErr()
+}
+
+// tag stamps the owner onto a neutron resource, e.g. so that the janitor can find it
+func tag(neutronClient *gophercloud.ServiceClient, resourceType, id string, owner checker.Owner) error {
+	_, err := attributestags.ReplaceAll(neutronClient, resourceType, id, attributestags.ReplaceAllOpts{
+		Tags: owner.Tags(),
+	}).Extract()
+	return err
+}
+
+// waitForStatus waits until get returns the expected status, failing if the resource goes into ERROR
+func waitForStatus(ctx context.Context, resource, status string, get func() (string, error)) error {
+	return checker.WaitFor(ctx, time.Second, func() (bool, error) {
+		s, err := get()
+		if err != nil {
+			return false, err
+		}
+		if s == "ERROR" {
+			return false, fmt.Errorf("%s is in status %s", resource, s)
+		}
+		return s == status, nil
+	})
+}
+
+// teardown records how to delete each created resource, so they can be deleted in reverse order
+type teardown struct {
+	steps []teardownStep
+}
+
+type teardownStep struct {
+	name   string
+	delete func() error
+}
+
+func (td *teardown) add(name string, fn func() error) {
+	td.steps = append(td.steps, teardownStep{name: name, delete: fn})
+}
+
+// run calls each of the delete functions in reverse order, returning the first error.  Deletion
+// continues after an error, so that as much as possible is cleaned up.
+func (td *teardown) run(ctx context.Context, output *bytes.Buffer) error {
+	var firstErr error
+	for i := len(td.steps) - 1; i >= 0; i-- {
+		s := &td.steps[i]
+		step := checker.StartStep(ctx, s.name)
+		err := step.End(s.delete())
+		if err != nil {
+			fmt.Fprintln(output, s.name, "failed:", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		fmt.Fprintln(output, s.name)
+	}
+	return firstErr
+}
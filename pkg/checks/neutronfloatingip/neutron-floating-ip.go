@@ -5,14 +5,20 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	neutronfloatingips "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
 
 	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
 )
 
+// resourceFloatingIP is the type of resource that is created by this check
+const resourceFloatingIP = "floating_ip"
+
 type checkNeutronFloatingIP struct {
 	pool string
 }
@@ -41,20 +47,92 @@ func (c *checkNeutronFloatingIP) Check(ctx context.Context, providerClient *goph
 	}
 	// don't set novaClient.Context so we can cleanup the floating IP even if the context is cancelled
 
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+
 	// Create a floating IP
+	owner := checker.GetOwner(ctx)
 	floatingIP, err := floatingips.Create(novaClient, floatingips.CreateOpts{
 		Pool: c.pool,
 	}).Extract()
 	if err != nil {
 		return err
 	}
+	checker.TrackResource(ctx, checker.Resource{Type: resourceFloatingIP, ID: floatingIP.ID, Name: floatingIP.IP, Created: owner.Created})
 	fmt.Fprintln(output, "Created floating IP", floatingIP.ID, floatingIP.IP)
 
+	// Tag the floating IP, which nova can't do, so that the janitor can find it if the delete fails.  Not all
+	// clouds support tags on floating IPs, so a failure here does not fail the check.
+	step := checker.StartStep(ctx, "tag floating IP")
+	_, err = attributestags.ReplaceAll(neutronClient, "floatingips", floatingIP.ID, attributestags.ReplaceAllOpts{
+		Tags: owner.Tags(),
+	}).Extract()
+	if step.End(err) != nil {
+		fmt.Fprintln(output, "Unable to tag floating IP", floatingIP.ID+":", err)
+	}
+
 	// Delete the floating IP
 	err = floatingips.Delete(novaClient, floatingIP.ID).ExtractErr()
 	if err != nil {
 		return err
 	}
+	checker.ReleaseResource(ctx, resourceFloatingIP, floatingIP.ID)
 	fmt.Fprintln(output, "Deleted floating IP", floatingIP.ID)
 	return nil
 }
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkNeutronFloatingIP) ResourceTypes() []string {
+	return []string{resourceFloatingIP}
+}
+
+// ListResources returns the floating IPs that were created by this check from this exporter instance
+func (c *checkNeutronFloatingIP) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	neutronClient.Context = ctx
+
+	filter := checker.OwnerFilter(ctx)
+	allPages, err := neutronfloatingips.List(neutronClient, neutronfloatingips.ListOpts{
+		Tags: strings.Join(filter.Tags(), ","),
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allFloatingIPs, err := neutronfloatingips.ExtractFloatingIPs(allPages)
+	if err != nil {
+		return nil, err
+	}
+	var resources []checker.Resource
+	for i := range allFloatingIPs {
+		fip := &allFloatingIPs[i]
+
+		// check the owner as well, in case neutron doesn't support tags and ignored the filter
+		owner, ok := checker.OwnerFromTags(fip.Tags)
+		if !ok || !owner.Matches(filter) {
+			continue
+		}
+		resources = append(resources, checker.Resource{Type: resourceFloatingIP, ID: fip.ID, Name: fip.FloatingIP, Created: owner.Created})
+	}
+	return resources, nil
+}
+
+// DeleteResource deletes a floating IP that was created by this check
+func (c *checkNeutronFloatingIP) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	novaClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	err = floatingips.Delete(novaClient, r.ID).ExtractErr()
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	if err == nil {
+		fmt.Fprintln(output, "Deleted floating IP", r.ID)
+	}
+	return err
+}
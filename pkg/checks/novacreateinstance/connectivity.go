@@ -0,0 +1,141 @@
+package novacreateinstance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// probeTimeout is the maximum time for each attempt to connect to the instance and read the banner
+const probeTimeout = 5 * time.Second
+
+// getAddress returns the address to use for connecting to the server.  If a floating network is configured,
+// then a floating IP is allocated and associated with the server's port, otherwise the fixed IP of the port
+// is used.  The returned cleanup function must always be called, even if an error is returned.
+func (c *checkNovaInstance) getAddress(ctx context.Context, neutronClient *gophercloud.ServiceClient, serverID, floatingNetworkID string, owner checker.Owner,
+	output *bytes.Buffer) (address string, cleanup func() error, err error) {
+
+	cleanup = func() error { return nil }
+
+	step := checker.StartStep(ctx, "find port")
+	allPages, err := ports.List(neutronClient, ports.ListOpts{
+		DeviceID: serverID,
+	}).AllPages()
+	if err != nil {
+		return "", cleanup, step.End(err)
+	}
+	allPorts, err := ports.ExtractPorts(allPages)
+	if err != nil {
+		return "", cleanup, step.End(err)
+	}
+	if len(allPorts) == 0 || len(allPorts[0].FixedIPs) == 0 {
+		return "", cleanup, step.End(errors.New("no port found for server"))
+	}
+	port := &allPorts[0]
+	step.End(nil)
+
+	if floatingNetworkID == "" {
+		address = port.FixedIPs[0].IPAddress
+		fmt.Fprintln(output, "using fixed IP", address)
+		return address, cleanup, nil
+	}
+
+	step = checker.StartStep(ctx, "create floating IP")
+	fip, err := floatingips.Create(neutronClient, floatingips.CreateOpts{
+		FloatingNetworkID: floatingNetworkID,
+		PortID:            port.ID,
+	}).Extract()
+	if err != nil {
+		return "", cleanup, step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceFloatingIP, ID: fip.ID, Name: fip.FloatingIP, Created: owner.Created})
+	fmt.Fprintln(output, "created floating IP", fip.ID, fip.FloatingIP)
+
+	cleanup = func() error {
+		step := checker.StartStep(ctx, "delete floating IP")
+		e := step.End(floatingips.Delete(neutronClient, fip.ID).ExtractErr())
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceFloatingIP, fip.ID)
+			fmt.Fprintln(output, "deleted floating IP", fip.ID)
+		}
+		return e
+	}
+
+	// tag the floating IP so that the janitor can find it.  Not all clouds support tags on floating IPs, so a
+	// failure here does not fail the check.
+	step = checker.StartStep(ctx, "tag floating IP")
+	_, err = attributestags.ReplaceAll(neutronClient, "floatingips", fip.ID, attributestags.ReplaceAllOpts{
+		Tags: owner.Tags(),
+	}).Extract()
+	if step.End(err) != nil {
+		fmt.Fprintln(output, "unable to tag floating IP", fip.ID+":", err)
+	}
+
+	return fip.FloatingIP, cleanup, nil
+}
+
+// waitForReachable waits until the configured port on the address accepts a TCP connection
+// and, if configured, the banner is read from it
+func (c *checkNovaInstance) waitForReachable(ctx context.Context, address string, output *bytes.Buffer) error {
+	hostport := net.JoinHostPort(address, strconv.Itoa(c.reachabilityPort))
+	fmt.Fprintln(output, "waiting for", hostport, "to be reachable")
+
+	attempts := 0
+	var lastErr error
+	err := checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		attempts++
+		lastErr = c.probe(ctx, hostport)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		fmt.Fprintln(output, "not reachable after", attempts, "attempts:", lastErr)
+		return err
+	}
+	fmt.Fprintln(output, hostport, "reachable after", attempts, "attempts")
+	return nil
+}
+
+// probe makes a single attempt to connect to the instance and read the banner
+func (c *checkNovaInstance) probe(ctx context.Context, hostport string) error {
+	dialer := net.Dialer{Timeout: probeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", hostport)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if c.reachabilityBanner == "" {
+		return nil
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(probeTimeout))
+	if err != nil {
+		return err
+	}
+	var received []byte
+	buf := make([]byte, 1024)
+	for len(received) < 64*1024 {
+		n, e := conn.Read(buf)
+		received = append(received, buf[:n]...)
+		if strings.Contains(string(received), c.reachabilityBanner) {
+			return nil
+		}
+		if e != nil {
+			return fmt.Errorf("banner not found: %w", e)
+		}
+	}
+	return errors.New("banner not found")
+}
@@ -0,0 +1,56 @@
+package novacreateinstance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// consoleContext is the number of console lines to include either side of the match in the output
+const consoleContext = 2
+
+// waitForConsole polls the console log of the server until the configured regex matches, then writes
+// the matching excerpt to the output
+func (c *checkNovaInstance) waitForConsole(ctx context.Context, novaClient *gophercloud.ServiceClient, serverID string, output *bytes.Buffer) error {
+	var console string
+	err := checker.WaitFor(ctx, 5*time.Second, func() (bool, error) {
+		var e error
+		console, e = servers.ShowConsoleOutput(novaClient, serverID, servers.ShowConsoleOutputOpts{}).Extract()
+		if e != nil {
+			return false, e
+		}
+		return c.consoleRegex.MatchString(console), nil
+	})
+	if err != nil {
+		fmt.Fprintln(output, "console did not match", c.consoleRegex.String())
+		fmt.Fprintln(output, excerpt(console, len(console), consoleContext*5))
+		return err
+	}
+
+	loc := c.consoleRegex.FindStringIndex(console)
+	fmt.Fprintln(output, "console matched", c.consoleRegex.String())
+	fmt.Fprintln(output, excerpt(console, loc[0], consoleContext))
+	return nil
+}
+
+// excerpt returns the line of the console containing offset, along with n lines either side
+func excerpt(console string, offset, n int) string {
+	lines := strings.Split(console, "\n")
+	line := strings.Count(console[:offset], "\n")
+	start := line - n
+	if start < 0 {
+		start = 0
+	}
+	end := line + n + 1
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return strings.Join(lines[start:end], "\n")
+}
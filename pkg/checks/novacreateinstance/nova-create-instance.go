@@ -7,10 +7,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/bootfromvolume"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	utilsflavors "github.com/gophercloud/utils/openstack/compute/v2/flavors"
 	utilsimages "github.com/gophercloud/utils/openstack/imageservice/v2/images"
@@ -20,23 +22,44 @@ import (
 )
 
 type checkNovaInstance struct {
-	serverName  string
-	flavorName  string
-	imageName   string
-	networkName string
-	autoDelete  bool
+	serverName          string
+	flavorName          string
+	imageName           string
+	networkName         string
+	autoDelete          bool
+	floatingNetworkName string
+	reachabilityPort    int
+	reachabilityBanner  string
+	bootFromVolume      bool
+	volumeSize          int
+	volumeType          string
+	consoleRegex        *regexp.Regexp
+}
+
+// resolvedIDs holds the IDs that are looked up from the configured names on each run
+type resolvedIDs struct {
+	flavorID          string
+	imageID           string
+	networkID         string
+	floatingNetworkID string
 }
 
 // New returns a new Checker instance that creates and deletes a Nova instance
 func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
 	c := &checkNovaInstance{
-		serverName:  "monitoring-test",
-		flavorName:  "m1.tiny",
-		imageName:   "cirros",
-		networkName: "admin-net",
-		autoDelete:  false,
+		serverName:          "monitoring-test",
+		flavorName:          "m1.tiny",
+		imageName:           "cirros",
+		networkName:         "admin-net",
+		autoDelete:          false,
+		floatingNetworkName: "", // don't allocate a floating IP
+		reachabilityPort:    0,  // don't check reachability
+		reachabilityBanner:  "",
+		bootFromVolume:      false,
+		volumeSize:          1,
+		volumeType:          "", // use the default volume type
 	}
-	if _, err := opts.String(c.GetName(), "server_name", &c.serverName); err != nil {
+	if _, err := opts.ResourceName(c.GetName(), "server_name", &c.serverName); err != nil {
 		return nil, err
 	}
 	if _, err := opts.String(c.GetName(), "flavor_name", &c.flavorName); err != nil {
@@ -51,6 +74,28 @@ func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.
 	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
 		return nil, err
 	}
+	if _, err := opts.String(c.GetName(), "floating_network_name", &c.floatingNetworkName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Int(c.GetName(), "reachability_port", &c.reachabilityPort); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "reachability_banner", &c.reachabilityBanner); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "boot_from_volume", &c.bootFromVolume); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Int(c.GetName(), "volume_size", &c.volumeSize); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "volume_type", &c.volumeType); err != nil {
+		return nil, err
+	}
+	consoleRegex := ""
+	if _, err := opts.String(c.GetName(), "console_regex", &consoleRegex); err != nil {
+		return nil, err
+	}
 
 	if c.serverName == "" {
 		return nil, errors.New("server_name must be non-empty")
@@ -64,6 +109,22 @@ func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.
 	if c.networkName == "" {
 		return nil, errors.New("network_name must be non-empty")
 	}
+	if c.reachabilityPort < 0 || c.reachabilityPort > 65535 {
+		return nil, errors.New("reachability_port must be between 0 and 65535")
+	}
+	if c.reachabilityBanner != "" && c.reachabilityPort == 0 {
+		return nil, errors.New("reachability_banner requires reachability_port")
+	}
+	if c.bootFromVolume && c.volumeSize < 1 {
+		return nil, errors.New("volume_size must be at least 1")
+	}
+	if consoleRegex != "" {
+		var err error
+		c.consoleRegex, err = regexp.Compile(consoleRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid console_regex: %w", err)
+		}
+	}
 
 	return c, nil
 }
@@ -72,7 +133,12 @@ func (c *checkNovaInstance) GetName() string {
 	return "nova_create_instance"
 }
 
-func (c *checkNovaInstance) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) error {
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkNovaInstance) ResourceNames() []string {
+	return []string{c.serverName}
+}
+
+func (c *checkNovaInstance) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
 
 	// construct our service clients
 
@@ -81,6 +147,9 @@ func (c *checkNovaInstance) Check(ctx context.Context, providerClient *gopherclo
 		return err
 	}
 	// don't set novaClient.Context so we can cleanup the instance even if the context is cancelled
+	if c.volumeType != "" {
+		novaClient.Microversion = "2.67" // minimum for volume_type in the block device mapping
+	}
 
 	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
 	if err != nil {
@@ -89,109 +158,227 @@ func (c *checkNovaInstance) Check(ctx context.Context, providerClient *gopherclo
 
 	// resolve names into IDs – do this here, not in the constructor, so that we behave correctly if the IDs change during the lifetime of the checker
 
-	flavorID, err := utilsflavors.IDFromName(novaClient, c.flavorName)
+	step := checker.StartStep(ctx, "resolve names")
+	ids, err := c.resolveIDs(novaClient, neutronClient)
 	if err != nil {
-		return err
+		return step.End(err)
 	}
-	imageID, err := utilsimages.IDFromName(novaClient, c.imageName)
+	step.End(nil)
+
+	// check the instance doesn't already exist
+
+	step = checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(novaClient, checker.OwnerFilter(ctx), output)
 	if err != nil {
-		return err
+		return step.End(err)
 	}
-	networkID, err := utilsnetworks.IDFromName(neutronClient, c.networkName)
+	step.End(nil)
+
+	// create the instance
+
+	step = checker.StartStep(ctx, "create server")
+	owner := checker.GetOwner(ctx)
+	server, err := servers.Create(novaClient, c.createOpts(ids, owner)).Extract()
 	if err != nil {
-		return err
+		return step.End(err)
 	}
+	serverID := server.ID
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceServer, ID: serverID, Name: c.serverName, Created: owner.Created})
 
-	// check the instance doesn't already exist
-
-	allPages, err := servers.List(novaClient, servers.ListOpts{
-		Name:   c.serverName,
-		Image:  imageID,
-		Flavor: flavorID,
-	}).AllPages()
+	b, err := json.MarshalIndent(server, "", "  ")
 	if err != nil {
 		return err
 	}
-	allServers, err := servers.ExtractServers(allPages)
+	fmt.Fprintln(output, string(b))
+
+	// always delete the instance, even if the context is cancelled
+
+	defer func() {
+		step := checker.StartStep(ctx, "delete server")
+		e := step.End(servers.Delete(novaClient, serverID).ExtractErr())
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceServer, serverID)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	// wait for the instance to be active
+
+	step = checker.StartStep(ctx, "wait for ACTIVE")
+	err = step.End(checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		if server.Status == "ACTIVE" {
+			return true, nil
+		}
+		server, err = servers.Get(novaClient, serverID).Extract()
+		return false, err
+	}))
 	if err != nil {
 		return err
 	}
-	switch len(allServers) {
-	case 0: // all good, go ahead and create it
-
-	case 1:
-		if !c.autoDelete {
-			return errors.New("server already exists")
+	if c.bootFromVolume {
+		for _, v := range server.AttachedVolumes {
+			fmt.Fprintln(output, "booted from volume", v.ID)
 		}
+	}
+	active := time.Now()
 
-		// delete the existing instance
-		serverID := allServers[0].ID
-		fmt.Fprintln(output, "deleting existing instance", serverID)
-		err = servers.Delete(novaClient, serverID).ExtractErr()
+	// optionally wait for the guest to boot, e.g. to show that it fetched its metadata
+
+	if c.consoleRegex != nil {
+		step = checker.StartStep(ctx, "wait for console")
+		err = step.End(c.waitForConsole(ctx, novaClient, serverID, output))
 		if err != nil {
 			return err
 		}
-
-	default:
-		return errors.New("found multiple servers")
+		checker.RecordMetric(ctx, checker.Metric{
+			Name:  "time_to_console_match_seconds",
+			Help:  "Time from the instance becoming ACTIVE until the console output matched console_regex",
+			Value: time.Since(active).Seconds(),
+		})
 	}
 
-	// create the instance
-
-	createOpts := servers.CreateOpts{
-		Name:      c.serverName,
-		ImageRef:  imageID,
-		FlavorRef: flavorID,
-		Networks:  []servers.Network{{UUID: networkID}},
+	if c.floatingNetworkName == "" && c.reachabilityPort == 0 {
+		return nil
 	}
 
-	server, err := servers.Create(novaClient, createOpts).Extract()
+	// find the address to connect to, optionally via a floating IP
+
+	address, cleanup, err := c.getAddress(ctx, neutronClient, serverID, ids.floatingNetworkID, owner, output)
+	defer func() {
+		e := cleanup()
+		if err == nil {
+			err = e
+		}
+	}()
 	if err != nil {
 		return err
 	}
-	serverID := server.ID
 
-	b, err := json.MarshalIndent(server, "", "  ")
+	if c.reachabilityPort == 0 {
+		return nil
+	}
+
+	step = checker.StartStep(ctx, "wait for reachable")
+	err = step.End(c.waitForReachable(ctx, address, output))
 	if err != nil {
 		return err
 	}
-	fmt.Fprintln(output, string(b))
-
-	// wait for the instance to be active
+	checker.RecordMetric(ctx, checker.Metric{
+		Name:  "time_to_reachable_seconds",
+		Help:  "Time from the instance becoming ACTIVE until it accepted a TCP connection",
+		Value: time.Since(active).Seconds(),
+	})
 
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	return nil
+}
 
-	cancelled := false
-wait:
-	for {
-		if server.Status == "ACTIVE" {
-			break
-		}
+// createOpts returns the options to create the server, either booting from the image onto ephemeral disk or
+// onto a new volume that is deleted along with the server
+func (c *checkNovaInstance) createOpts(ids *resolvedIDs, owner checker.Owner) servers.CreateOptsBuilder {
+	createOpts := servers.CreateOpts{
+		Name:      c.serverName,
+		ImageRef:  ids.imageID,
+		FlavorRef: ids.flavorID,
+		Networks:  []servers.Network{{UUID: ids.networkID}},
+		Metadata:  owner.Metadata(),
+	}
+	if !c.bootFromVolume {
+		return createOpts
+	}
 
-		select {
-		case <-ctx.Done():
-			// don't return immediately, we need to delete the instance
-			cancelled = true
-			break wait
+	createOpts.ImageRef = ""
+	return bootfromvolume.CreateOptsExt{
+		CreateOptsBuilder: createOpts,
+		BlockDevice: []bootfromvolume.BlockDevice{
+			{
+				SourceType:          bootfromvolume.SourceImage,
+				UUID:                ids.imageID,
+				DestinationType:     bootfromvolume.DestinationVolume,
+				VolumeSize:          c.volumeSize,
+				VolumeType:          c.volumeType,
+				BootIndex:           0,
+				DeleteOnTermination: true,
+			},
+		},
+	}
+}
 
-		case <-ticker.C:
-		}
-		server, err = servers.Get(novaClient, serverID).Extract()
+// resolveIDs looks up the IDs for the configured flavor, image and networks
+func (c *checkNovaInstance) resolveIDs(novaClient, neutronClient *gophercloud.ServiceClient) (*resolvedIDs, error) {
+	var ids resolvedIDs
+	var err error
+	ids.flavorID, err = utilsflavors.IDFromName(novaClient, c.flavorName)
+	if err != nil {
+		return nil, err
+	}
+	ids.imageID, err = utilsimages.IDFromName(novaClient, c.imageName)
+	if err != nil {
+		return nil, err
+	}
+	ids.networkID, err = utilsnetworks.IDFromName(neutronClient, c.networkName)
+	if err != nil {
+		return nil, err
+	}
+	if c.floatingNetworkName != "" {
+		ids.floatingNetworkID, err = utilsnetworks.IDFromName(neutronClient, c.floatingNetworkName)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
+	return &ids, nil
+}
 
-	// delete the instance
-
-	err = servers.Delete(novaClient, server.ID).ExtractErr()
-	if cancelled {
-		return errors.New("cancelled")
-	}
+// deleteExisting checks whether an instance created by this check from this exporter instance already exists and,
+// if auto_delete is set, deletes it.  Servers of the same name that belong to someone else are ignored.
+func (c *checkNovaInstance) deleteExisting(novaClient *gophercloud.ServiceClient, filter checker.Owner, output *bytes.Buffer) error {
+	allServers, err := c.listServers(novaClient, filter)
 	if err != nil {
 		return err
 	}
+	switch len(allServers) {
+	case 0: // all good, go ahead and create it
+
+	case 1:
+		if !c.autoDelete {
+			return errors.New("server already exists")
+		}
+
+		// delete the existing instance
+		serverID := allServers[0].ID
+		fmt.Fprintln(output, "deleting existing instance", serverID)
+		return servers.Delete(novaClient, serverID).ExtractErr()
+
+	default:
+		return errors.New("found multiple servers")
+	}
 
 	return nil
 }
+
+// listServers returns the servers with the configured name that were created by the owner in the filter
+func (c *checkNovaInstance) listServers(novaClient *gophercloud.ServiceClient, filter checker.Owner) ([]servers.Server, error) {
+	allPages, err := servers.List(novaClient, servers.ListOpts{
+		Name: c.serverName,
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allServers, err := servers.ExtractServers(allPages)
+	if err != nil {
+		return nil, err
+	}
+	var owned []servers.Server
+	for i := range allServers {
+		s := &allServers[i]
+		if s.Name != c.serverName {
+			continue // the nova name filter is a regex
+		}
+		if owner, ok := checker.OwnerFromMetadata(s.Metadata); ok && owner.Matches(filter) {
+			owned = append(owned, *s)
+		}
+	}
+	return owned, nil
+}
@@ -0,0 +1,108 @@
+package novacreateinstance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// resource types that are created by this check
+const (
+	resourceServer     = "server"
+	resourceFloatingIP = "floating_ip"
+)
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkNovaInstance) ResourceTypes() []string {
+	return []string{resourceServer, resourceFloatingIP}
+}
+
+// ListResources returns the servers and floating IPs that were created by this check from this exporter instance
+func (c *checkNovaInstance) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	novaClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	novaClient.Context = ctx
+
+	filter := checker.OwnerFilter(ctx)
+	allServers, err := c.listServers(novaClient, filter)
+	if err != nil {
+		return nil, err
+	}
+	var resources []checker.Resource
+	for i := range allServers {
+		s := &allServers[i]
+		owner, _ := checker.OwnerFromMetadata(s.Metadata)
+		resources = append(resources, checker.Resource{Type: resourceServer, ID: s.ID, Name: s.Name, Created: owner.Created})
+	}
+
+	if c.floatingNetworkName == "" {
+		return resources, nil
+	}
+
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	neutronClient.Context = ctx
+
+	allPages, err := floatingips.List(neutronClient, floatingips.ListOpts{
+		Tags: strings.Join(filter.Tags(), ","),
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allFloatingIPs, err := floatingips.ExtractFloatingIPs(allPages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range allFloatingIPs {
+		fip := &allFloatingIPs[i]
+		owner, ok := checker.OwnerFromTags(fip.Tags)
+		if !ok || !owner.Matches(filter) {
+			continue // in case the server ignored the filter
+		}
+		resources = append(resources, checker.Resource{Type: resourceFloatingIP, ID: fip.ID, Name: fip.FloatingIP, Created: owner.Created})
+	}
+
+	return resources, nil
+}
+
+// DeleteResource deletes a server or floating IP that was created by this check
+func (c *checkNovaInstance) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	var err error
+	switch r.Type {
+	case resourceServer:
+		var novaClient *gophercloud.ServiceClient
+		novaClient, err = openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+		if err != nil {
+			return err
+		}
+		err = servers.Delete(novaClient, r.ID).ExtractErr()
+
+	case resourceFloatingIP:
+		var neutronClient *gophercloud.ServiceClient
+		neutronClient, err = openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+		if err != nil {
+			return err
+		}
+		err = floatingips.Delete(neutronClient, r.ID).ExtractErr()
+	}
+
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	if err == nil {
+		fmt.Fprintln(output, "deleted", r.Type, r.ID)
+	}
+	return err
+}
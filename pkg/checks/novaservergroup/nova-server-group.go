@@ -0,0 +1,417 @@
+// Package novaservergroup implements a `checker.Check` that boots instances into an anti-affinity server group
+// and checks that they are scheduled onto different hosts
+package novaservergroup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/schedulerhints"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/servergroups"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	utilsflavors "github.com/gophercloud/utils/openstack/compute/v2/flavors"
+	utilsimages "github.com/gophercloud/utils/openstack/imageservice/v2/images"
+	utilsnetworks "github.com/gophercloud/utils/openstack/networking/v2/networks"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// cleanupTimeout is how long we allow for deleting the instances once the check context is done
+const cleanupTimeout = 5 * time.Minute
+
+// numServers is how many instances we boot into the server group
+const numServers = 2
+
+// resource types that are created by this check
+const (
+	resourceServer      = "server"
+	resourceServerGroup = "server_group"
+)
+
+type checkNovaServerGroup struct {
+	name        string
+	flavorName  string
+	imageName   string
+	networkName string
+	autoDelete  bool
+}
+
+// New returns a new Checker instance that checks anti-affinity scheduling
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkNovaServerGroup{
+		name:        "monitoring-test-anti-affinity",
+		flavorName:  "m1.tiny",
+		imageName:   "cirros",
+		networkName: "admin-net",
+		autoDelete:  false,
+	}
+	if _, err := opts.ResourceName(c.GetName(), "name", &c.name); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "flavor_name", &c.flavorName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "image_name", &c.imageName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "network_name", &c.networkName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
+		return nil, err
+	}
+
+	if c.name == "" {
+		return nil, errors.New("name must be non-empty")
+	}
+	if c.flavorName == "" {
+		return nil, errors.New("flavor_name must be non-empty")
+	}
+	if c.imageName == "" {
+		return nil, errors.New("image_name must be non-empty")
+	}
+	if c.networkName == "" {
+		return nil, errors.New("network_name must be non-empty")
+	}
+
+	return c, nil
+}
+
+func (c *checkNovaServerGroup) GetName() string {
+	return "nova_server_group_anti_affinity"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkNovaServerGroup) ResourceNames() []string {
+	return []string{c.name}
+}
+
+func (c *checkNovaServerGroup) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	novaClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set novaClient.Context so we can cleanup the instances even if the context is cancelled
+
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+
+	// resolve names into IDs
+
+	step := checker.StartStep(ctx, "resolve names")
+	flavorID, err := utilsflavors.IDFromName(novaClient, c.flavorName)
+	if err != nil {
+		return step.End(err)
+	}
+	imageID, err := utilsimages.IDFromName(novaClient, c.imageName)
+	if err != nil {
+		return step.End(err)
+	}
+	networkID, err := utilsnetworks.IDFromName(neutronClient, c.networkName)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// check nothing already exists
+
+	step = checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(ctx, novaClient, output)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// create the server group
+
+	step = checker.StartStep(ctx, "create server group")
+	sg, err := servergroups.Create(novaClient, servergroups.CreateOpts{
+		Name:     c.name,
+		Policies: []string{"anti-affinity"},
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceServerGroup, ID: sg.ID, Name: c.name})
+	fmt.Fprintln(output, "created server group", sg.ID)
+
+	// always delete the servers and server group, even if the context is cancelled
+
+	var serverIDs []string
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		step := checker.StartStep(ctx, "delete servers")
+		e := step.End(deleteServers(cleanupCtx, novaClient, serverIDs, output))
+		if e == nil {
+			for _, id := range serverIDs {
+				checker.ReleaseResource(ctx, resourceServer, id)
+			}
+		}
+		if err == nil {
+			err = e
+		}
+		step = checker.StartStep(ctx, "delete server group")
+		e = step.End(servergroups.Delete(novaClient, sg.ID).ExtractErr())
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceServerGroup, sg.ID)
+			fmt.Fprintln(output, "deleted server group", sg.ID)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	// boot the servers into the group
+
+	step = checker.StartStep(ctx, "create servers")
+	owner := checker.GetOwner(ctx)
+	for i := 0; i < numServers; i++ {
+		server, e := servers.Create(novaClient, schedulerhints.CreateOptsExt{
+			CreateOptsBuilder: servers.CreateOpts{
+				Name:      c.serverName(i),
+				ImageRef:  imageID,
+				FlavorRef: flavorID,
+				Networks:  []servers.Network{{UUID: networkID}},
+				Metadata:  owner.Metadata(),
+			},
+			SchedulerHints: schedulerhints.SchedulerHints{
+				Group: sg.ID,
+			},
+		}).Extract()
+		if e != nil {
+			return step.End(e)
+		}
+		serverIDs = append(serverIDs, server.ID)
+		checker.TrackResource(ctx, checker.Resource{Type: resourceServer, ID: server.ID, Name: c.serverName(i), Created: owner.Created})
+		fmt.Fprintln(output, "created server", server.ID, c.serverName(i))
+	}
+	step.End(nil)
+
+	// wait for the servers to be active, and check where they landed
+
+	step = checker.StartStep(ctx, "wait for ACTIVE")
+	hosts := make(map[string]string, numServers) // server ID -> host ID
+	err = step.End(checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		for _, id := range serverIDs {
+			if _, done := hosts[id]; done {
+				continue
+			}
+			server, e := servers.Get(novaClient, id).Extract()
+			if e != nil {
+				return false, e
+			}
+			switch server.Status {
+			case "ACTIVE":
+				hosts[id] = server.HostID
+			case "ERROR":
+				reason := ""
+				if server.Fault.Message != "" {
+					reason = ": " + server.Fault.Message
+				}
+				return false, fmt.Errorf("server %s is in status ERROR%s", id, reason)
+			}
+		}
+		return len(hosts) == len(serverIDs), nil
+	}))
+	if err != nil {
+		return err
+	}
+
+	step = checker.StartStep(ctx, "check hosts")
+	seen := map[string]string{} // host ID -> server ID
+	for _, id := range serverIDs {
+		hostID := hosts[id]
+		fmt.Fprintln(output, "server", id, "hostId", hostID)
+		if other, found := seen[hostID]; found {
+			return step.End(fmt.Errorf("servers %s and %s are on the same host", other, id))
+		}
+		seen[hostID] = id
+	}
+	step.End(nil)
+
+	return nil
+}
+
+// serverName returns the name of the i'th server in the group
+func (c *checkNovaServerGroup) serverName(i int) string {
+	return fmt.Sprintf("%s-%d", c.name, i)
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkNovaServerGroup) ResourceTypes() []string {
+	return []string{resourceServer, resourceServerGroup}
+}
+
+// ListResources returns the servers that were created by this check from this exporter instance, along with
+// the server groups that contain them.  Servers are listed first so that the janitor deletes them before the
+// server group.  Server groups can't be tagged, so an empty group is only found if it was registered with
+// checker.TrackResource.
+func (c *checkNovaServerGroup) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	novaClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	novaClient.Context = ctx
+
+	allPages, err := servers.List(novaClient, servers.ListOpts{
+		Name: "^" + regexp.QuoteMeta(c.name) + "-[0-9]+$",
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allServers, err := servers.ExtractServers(allPages)
+	if err != nil {
+		return nil, err
+	}
+	filter := checker.OwnerFilter(ctx)
+	var resources []checker.Resource
+	created := make(map[string]time.Time) // server ID -> created
+	for i := range allServers {
+		s := &allServers[i]
+		owner, ok := checker.OwnerFromMetadata(s.Metadata)
+		if !ok || !owner.Matches(filter) {
+			continue
+		}
+		resources = append(resources, checker.Resource{Type: resourceServer, ID: s.ID, Name: s.Name, Created: owner.Created})
+		created[s.ID] = owner.Created
+	}
+
+	allPages, err = servergroups.List(novaClient, servergroups.ListOpts{}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allServerGroups, err := servergroups.ExtractServerGroups(allPages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range allServerGroups {
+		sg := &allServerGroups[i]
+		if sg.Name != c.name {
+			continue
+		}
+
+		// server groups have no creation time, so use the earliest of our servers in the group
+		var earliest time.Time
+		for _, id := range sg.Members {
+			if t, found := created[id]; found && (earliest.IsZero() || t.Before(earliest)) {
+				earliest = t
+			}
+		}
+		if earliest.IsZero() {
+			continue // none of our servers are in the group
+		}
+		resources = append(resources, checker.Resource{Type: resourceServerGroup, ID: sg.ID, Name: sg.Name, Created: earliest})
+	}
+
+	return resources, nil
+}
+
+// DeleteResource deletes a server or server group that was created by this check
+func (c *checkNovaServerGroup) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	novaClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+
+	switch r.Type {
+	case resourceServer:
+		err = deleteServers(ctx, novaClient, []string{r.ID}, output)
+	case resourceServerGroup:
+		err = servergroups.Delete(novaClient, r.ID).ExtractErr()
+		if err == nil {
+			fmt.Fprintln(output, "deleted server group", r.ID)
+		}
+	}
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	return err
+}
+
+// deleteExisting checks whether the servers or server group already exist and, if auto_delete is set, deletes them
+func (c *checkNovaServerGroup) deleteExisting(ctx context.Context, novaClient *gophercloud.ServiceClient, output *bytes.Buffer) error {
+
+	// the nova name filter is a regex
+	allPages, err := servers.List(novaClient, servers.ListOpts{
+		Name: "^" + regexp.QuoteMeta(c.name) + "-[0-9]+$",
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allServers, err := servers.ExtractServers(allPages)
+	if err != nil {
+		return err
+	}
+
+	allPages, err = servergroups.List(novaClient, servergroups.ListOpts{}).AllPages()
+	if err != nil {
+		return err
+	}
+	allServerGroups, err := servergroups.ExtractServerGroups(allPages)
+	if err != nil {
+		return err
+	}
+	var serverGroupIDs []string
+	for i := range allServerGroups {
+		if allServerGroups[i].Name == c.name {
+			serverGroupIDs = append(serverGroupIDs, allServerGroups[i].ID)
+		}
+	}
+
+	if len(allServers) == 0 && len(serverGroupIDs) == 0 {
+		return nil // all good, go ahead and create them
+	}
+	if !c.autoDelete {
+		return errors.New("servers or server group already exist")
+	}
+
+	var serverIDs []string
+	for i := range allServers {
+		serverIDs = append(serverIDs, allServers[i].ID)
+	}
+	fmt.Fprintln(output, "deleting existing servers", serverIDs)
+	err = deleteServers(ctx, novaClient, serverIDs, output)
+	if err != nil {
+		return err
+	}
+	for _, id := range serverGroupIDs {
+		fmt.Fprintln(output, "deleting existing server group", id)
+		err = servergroups.Delete(novaClient, id).ExtractErr()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteServers deletes the servers and waits for them to be gone
+func deleteServers(ctx context.Context, novaClient *gophercloud.ServiceClient, serverIDs []string, output *bytes.Buffer) error {
+	for _, id := range serverIDs {
+		err := servers.Delete(novaClient, id).ExtractErr()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(output, "deleting server", id)
+	}
+
+	return checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		for _, id := range serverIDs {
+			_, err := servers.Get(novaClient, id).Extract()
+			if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+				continue
+			}
+			return false, err
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,362 @@
+// Package octaviacreateloadbalancer implements a `checker.Check` that creates/deletes an Octavia load balancer
+package octaviacreateloadbalancer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/listeners"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/loadbalancers"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+	utilssubnets "github.com/gophercloud/utils/openstack/networking/v2/subnets"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// cleanupTimeout is how long we allow for deleting the load balancer once the check context is done
+const cleanupTimeout = 5 * time.Minute
+
+// resourceLoadBalancer is the type of resource that is created by this check
+const resourceLoadBalancer = "loadbalancer"
+
+type checkOctaviaLoadBalancer struct {
+	name          string
+	vipSubnetName string
+	protocol      string
+	port          int
+	memberAddress string
+	memberPort    int
+	autoDelete    bool
+}
+
+// New returns a new Checker instance that creates and deletes an Octavia load balancer
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkOctaviaLoadBalancer{
+		name:          "monitoring-test",
+		vipSubnetName: "",
+		protocol:      "HTTP",
+		port:          80,
+		memberAddress: "", // don't add a member
+		memberPort:    80,
+		autoDelete:    false,
+	}
+	if _, err := opts.ResourceName(c.GetName(), "name", &c.name); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "vip_subnet_name", &c.vipSubnetName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "protocol", &c.protocol); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Int(c.GetName(), "port", &c.port); err != nil {
+		return nil, err
+	}
+	if _, err := opts.String(c.GetName(), "member_address", &c.memberAddress); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Int(c.GetName(), "member_port", &c.memberPort); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Bool(c.GetName(), "auto_delete", &c.autoDelete); err != nil {
+		return nil, err
+	}
+
+	if c.vipSubnetName == "" {
+		return nil, fmt.Errorf("%s: vip_subnet_name must be set: %w", c.GetName(), checker.ErrNotConfigured)
+	}
+	if c.name == "" {
+		return nil, errors.New("name must be non-empty")
+	}
+	c.protocol = strings.ToUpper(c.protocol)
+	if c.protocol == "" {
+		return nil, errors.New("protocol must be non-empty")
+	}
+
+	return c, nil
+}
+
+func (c *checkOctaviaLoadBalancer) GetName() string {
+	return "octavia_create_loadbalancer"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkOctaviaLoadBalancer) ResourceNames() []string {
+	return []string{c.name}
+}
+
+func (c *checkOctaviaLoadBalancer) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	octaviaClient, err := openstack.NewLoadBalancerV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set octaviaClient.Context so we can cleanup the load balancer even if the context is cancelled
+
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+
+	// resolve names into IDs
+
+	step := checker.StartStep(ctx, "resolve names")
+	subnetID, err := utilssubnets.IDFromName(neutronClient, c.vipSubnetName)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// check the load balancer doesn't already exist
+
+	step = checker.StartStep(ctx, "check existing")
+	err = c.deleteExisting(ctx, octaviaClient, output)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	// create the load balancer
+
+	step = checker.StartStep(ctx, "create loadbalancer")
+	owner := checker.GetOwner(ctx)
+	lb, err := loadbalancers.Create(octaviaClient, loadbalancers.CreateOpts{
+		Name:        c.name,
+		VipSubnetID: subnetID,
+		Tags:        owner.Tags(),
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceLoadBalancer, ID: lb.ID, Name: c.name, Created: owner.Created})
+	fmt.Fprintln(output, "created loadbalancer", lb.ID, lb.VipAddress)
+
+	// always delete the load balancer, even if the context is cancelled
+
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		step := checker.StartStep(ctx, "delete loadbalancer")
+		e := step.End(deleteLoadBalancer(cleanupCtx, octaviaClient, lb.ID, output))
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceLoadBalancer, lb.ID)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	step = checker.StartStep(ctx, "wait for loadbalancer ACTIVE")
+	err = step.End(waitForActive(ctx, octaviaClient, lb.ID))
+	if err != nil {
+		return err
+	}
+
+	return c.createChildren(ctx, octaviaClient, lb.ID, subnetID, output)
+}
+
+// createChildren creates the listener, pool and optional member, waiting for the load balancer to become ACTIVE after each
+func (c *checkOctaviaLoadBalancer) createChildren(ctx context.Context, octaviaClient *gophercloud.ServiceClient, lbID, subnetID string, output *bytes.Buffer) error {
+	step := checker.StartStep(ctx, "create listener")
+	listener, err := listeners.Create(octaviaClient, listeners.CreateOpts{
+		Name:           c.name,
+		LoadbalancerID: lbID,
+		Protocol:       listeners.Protocol(c.protocol),
+		ProtocolPort:   c.port,
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "created listener", listener.ID)
+
+	step = checker.StartStep(ctx, "wait for listener ACTIVE")
+	err = step.End(waitForActive(ctx, octaviaClient, lbID))
+	if err != nil {
+		return err
+	}
+
+	step = checker.StartStep(ctx, "create pool")
+	pool, err := pools.Create(octaviaClient, pools.CreateOpts{
+		Name:       c.name,
+		ListenerID: listener.ID,
+		LBMethod:   pools.LBMethodRoundRobin,
+		Protocol:   pools.Protocol(c.protocol),
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "created pool", pool.ID)
+
+	step = checker.StartStep(ctx, "wait for pool ACTIVE")
+	err = step.End(waitForActive(ctx, octaviaClient, lbID))
+	if err != nil {
+		return err
+	}
+
+	if c.memberAddress == "" {
+		return nil
+	}
+
+	step = checker.StartStep(ctx, "create member")
+	member, err := pools.CreateMember(octaviaClient, pool.ID, pools.CreateMemberOpts{
+		Name:         c.name,
+		Address:      c.memberAddress,
+		ProtocolPort: c.memberPort,
+		SubnetID:     subnetID,
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "created member", member.ID, member.Address)
+
+	step = checker.StartStep(ctx, "wait for member ACTIVE")
+	return step.End(waitForActive(ctx, octaviaClient, lbID))
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkOctaviaLoadBalancer) ResourceTypes() []string {
+	return []string{resourceLoadBalancer}
+}
+
+// ListResources returns the load balancers that were created by this check from this exporter instance
+func (c *checkOctaviaLoadBalancer) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	octaviaClient, err := openstack.NewLoadBalancerV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	octaviaClient.Context = ctx
+
+	filter := checker.OwnerFilter(ctx)
+	allPages, err := loadbalancers.List(octaviaClient, loadbalancers.ListOpts{
+		Name: c.name,
+		Tags: filter.Tags(),
+	}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	allLoadBalancers, err := loadbalancers.ExtractLoadBalancers(allPages)
+	if err != nil {
+		return nil, err
+	}
+	var resources []checker.Resource
+	for i := range allLoadBalancers {
+		lb := &allLoadBalancers[i]
+		owner, ok := checker.OwnerFromTags(lb.Tags)
+		if !ok || !owner.Matches(filter) {
+			continue // in case the server ignored the filter
+		}
+		resources = append(resources, checker.Resource{Type: resourceLoadBalancer, ID: lb.ID, Name: lb.Name, Created: owner.Created})
+	}
+	return resources, nil
+}
+
+// DeleteResource deletes a load balancer that was created by this check
+func (c *checkOctaviaLoadBalancer) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	octaviaClient, err := openstack.NewLoadBalancerV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	err = deleteLoadBalancer(ctx, octaviaClient, r.ID, output)
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	return err
+}
+
+// deleteExisting checks whether the load balancer already exists and, if auto_delete is set, deletes it
+func (c *checkOctaviaLoadBalancer) deleteExisting(ctx context.Context, octaviaClient *gophercloud.ServiceClient, output *bytes.Buffer) error {
+	allPages, err := loadbalancers.List(octaviaClient, loadbalancers.ListOpts{
+		Name: c.name,
+	}).AllPages()
+	if err != nil {
+		return err
+	}
+	allLoadBalancers, err := loadbalancers.ExtractLoadBalancers(allPages)
+	if err != nil {
+		return err
+	}
+	switch len(allLoadBalancers) {
+	case 0: // all good, go ahead and create it
+
+	case 1:
+		if !c.autoDelete {
+			return errors.New("loadbalancer already exists")
+		}
+
+		// delete the existing load balancer
+		lbID := allLoadBalancers[0].ID
+		fmt.Fprintln(output, "deleting existing loadbalancer", lbID)
+		return deleteLoadBalancer(ctx, octaviaClient, lbID, output)
+
+	default:
+		return errors.New("found multiple loadbalancers")
+	}
+
+	return nil
+}
+
+// deleteLoadBalancer waits for any pending operation to complete, then deletes the load balancer
+// and all of its children, waiting until it is gone
+func deleteLoadBalancer(ctx context.Context, octaviaClient *gophercloud.ServiceClient, lbID string, output *bytes.Buffer) error {
+
+	// a load balancer is immutable while it has a pending operation
+	err := checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		lb, e := loadbalancers.Get(octaviaClient, lbID).Extract()
+		if e != nil {
+			return false, e
+		}
+		return !strings.HasPrefix(lb.ProvisioningStatus, "PENDING_"), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = loadbalancers.Delete(octaviaClient, lbID, loadbalancers.DeleteOpts{Cascade: true}).ExtractErr()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "deleting loadbalancer", lbID)
+
+	return checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		lb, e := loadbalancers.Get(octaviaClient, lbID).Extract()
+		if _, notfound := e.(gophercloud.ErrDefault404); notfound {
+			return true, nil
+		}
+		if e != nil {
+			return false, e
+		}
+		switch lb.ProvisioningStatus {
+		case "DELETED":
+			return true, nil
+		case "ERROR":
+			return false, fmt.Errorf("loadbalancer %s failed to delete", lbID)
+		}
+		return false, nil
+	})
+}
+
+// waitForActive waits until the load balancer provisioning_status is ACTIVE, failing if it goes into ERROR
+func waitForActive(ctx context.Context, octaviaClient *gophercloud.ServiceClient, lbID string) error {
+	return checker.WaitFor(ctx, 2*time.Second, func() (bool, error) {
+		lb, err := loadbalancers.Get(octaviaClient, lbID).Extract()
+		if err != nil {
+			return false, err
+		}
+		switch lb.ProvisioningStatus {
+		case "ACTIVE":
+			return true, nil
+		case "ERROR":
+			return false, fmt.Errorf("loadbalancer %s is in provisioning_status ERROR", lbID)
+		}
+		return false, nil
+	})
+}
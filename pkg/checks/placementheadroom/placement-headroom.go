@@ -0,0 +1,271 @@
+// Package placementheadroom implements a `checker.Check` that uses the Placement API to count how many instances
+// of a flavor can still be scheduled.
+//
+// Resource classes that a compute node doesn't provide itself, e.g. DISK_GB from a sharing storage provider, are
+// counted against the total free capacity of the providers that do provide them.  This assumes that the shared
+// capacity is available to every compute node, i.e. aggregates are not taken into account, so the headroom might
+// be too high if there are several sharing providers.
+package placementheadroom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/placement/v1/resourceproviders"
+	utilsflavors "github.com/gophercloud/utils/openstack/compute/v2/flavors"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// microversion is the placement API microversion that we use, this is the minimum that returns provider_summaries
+// from allocation_candidates
+const microversion = "1.10"
+
+type checkPlacementHeadroom struct {
+	flavorName   string
+	minInstances int
+}
+
+// New returns a new Checker instance that checks how many instances of a flavor can be placed
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkPlacementHeadroom{
+		flavorName:   "m1.tiny",
+		minInstances: 1,
+	}
+	if _, err := opts.String(c.GetName(), "flavor_name", &c.flavorName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Int(c.GetName(), "min_instances", &c.minInstances); err != nil {
+		return nil, err
+	}
+
+	if c.flavorName == "" {
+		return nil, errors.New("flavor_name must be non-empty")
+	}
+	if c.minInstances < 0 {
+		return nil, errors.New("min_instances must not be negative")
+	}
+
+	return c, nil
+}
+
+func (c *checkPlacementHeadroom) GetName() string {
+	return "placement_headroom"
+}
+
+func (c *checkPlacementHeadroom) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) error {
+	novaClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	novaClient.Context = ctx
+
+	placementClient, err := openstack.NewPlacementV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	placementClient.Context = ctx
+	placementClient.Microversion = microversion
+
+	// find the resources that the flavor needs
+
+	step := checker.StartStep(ctx, "resolve flavor")
+	resources, err := c.flavorResources(novaClient)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "flavor", c.flavorName, "requires", formatResources(resources))
+
+	// count how many instances fit on each resource provider
+
+	step = checker.StartStep(ctx, "list resource providers")
+	allPages, err := resourceproviders.List(placementClient, resourceproviders.ListOpts{}).AllPages()
+	if err != nil {
+		return step.End(err)
+	}
+	allProviders, err := resourceproviders.ExtractResourceProviders(allPages)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	step = checker.StartStep(ctx, "get inventories and usages")
+	headroom := 0
+	shared := make(map[string]int)    // instances that fit in the capacity of sharing providers, by resource class
+	external := make(map[string]bool) // resource classes that some compute node doesn't provide itself
+	for i := range allProviders {
+		rp := &allProviders[i]
+		fits, e := providerHeadroom(placementClient, rp.UUID, resources)
+		if e != nil {
+			return step.End(fmt.Errorf("resource provider %s: %w", rp.Name, e))
+		}
+		if len(fits) == 0 {
+			continue // doesn't provide any of the resources
+		}
+		if !isCompute(fits) {
+			// e.g. a sharing storage provider for DISK_GB
+			for rc, n := range fits {
+				shared[rc] += n
+			}
+			fmt.Fprintln(output, "resource provider", rp.Name, "fits", formatResources(fits))
+			continue
+		}
+		n := -1
+		for rc := range resources {
+			f, ok := fits[rc]
+			if !ok {
+				external[rc] = true
+				continue
+			}
+			if n < 0 || f < n {
+				n = f
+			}
+		}
+		fmt.Fprintln(output, "resource provider", rp.Name, "fits", n)
+		headroom += n
+	}
+	var externalClasses []string
+	for rc := range external {
+		externalClasses = append(externalClasses, rc)
+	}
+	sort.Strings(externalClasses)
+	for _, rc := range externalClasses {
+		fmt.Fprintln(output, "shared", rc, "fits", shared[rc])
+		if shared[rc] < headroom {
+			headroom = shared[rc]
+		}
+	}
+	step.End(nil)
+
+	// cross-check with the allocation candidates, this also takes into account things like aggregates and traits
+	// that the scheduler will honour
+
+	step = checker.StartStep(ctx, "get allocation candidates")
+	candidates, err := allocationCandidates(placementClient, resources)
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "allocation candidates", candidates)
+	if candidates == 0 {
+		headroom = 0
+	}
+
+	fmt.Fprintln(output, "headroom", headroom, "instances of", c.flavorName)
+	checker.RecordMetric(ctx, checker.Metric{
+		Name: "placement_headroom_instances",
+		Help: "How many more instances of the flavor can be placed",
+		Labels: map[string]string{
+			"flavor": c.flavorName,
+		},
+		Value: float64(headroom),
+	})
+
+	if headroom < c.minInstances {
+		return fmt.Errorf("only %d instances of %s can be placed, want at least %d", headroom, c.flavorName, c.minInstances)
+	}
+
+	return nil
+}
+
+// flavorResources returns the placement resource classes and amounts required by the flavor
+func (c *checkPlacementHeadroom) flavorResources(novaClient *gophercloud.ServiceClient) (map[string]int, error) {
+	flavorID, err := utilsflavors.IDFromName(novaClient, c.flavorName)
+	if err != nil {
+		return nil, err
+	}
+	flavor, err := flavors.Get(novaClient, flavorID).Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	resources := map[string]int{}
+	if flavor.VCPUs > 0 {
+		resources["VCPU"] = flavor.VCPUs
+	}
+	if flavor.RAM > 0 {
+		resources["MEMORY_MB"] = flavor.RAM
+	}
+
+	// swap is in MB, but nova requests whole GB of disk
+	disk := flavor.Disk + flavor.Ephemeral + (flavor.Swap+1023)/1024
+	if disk > 0 {
+		resources["DISK_GB"] = disk
+	}
+
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("flavor %s requires no resources", c.flavorName)
+	}
+	return resources, nil
+}
+
+// isCompute returns true if the provider looks like a compute node, rather than e.g. a sharing storage provider
+func isCompute(fits map[string]int) bool {
+	_, vcpu := fits["VCPU"]
+	_, memory := fits["MEMORY_MB"]
+	return vcpu || memory
+}
+
+// providerHeadroom returns how many times the amount of each resource class fits into the free capacity of the
+// resource provider.  Resource classes that the provider does not have an inventory of are omitted.
+func providerHeadroom(placementClient *gophercloud.ServiceClient, uuid string, resources map[string]int) (map[string]int, error) {
+	inventories, err := resourceproviders.GetInventories(placementClient, uuid).Extract()
+	if err != nil {
+		return nil, err
+	}
+	usages, err := resourceproviders.GetUsages(placementClient, uuid).Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	fits := make(map[string]int)
+	for rc, amount := range resources {
+		inventory, ok := inventories.Inventories[rc]
+		if !ok {
+			continue
+		}
+		if inventory.MaxUnit > 0 && amount > inventory.MaxUnit {
+			fits[rc] = 0
+			continue
+		}
+		capacity := int(float32(inventory.Total-inventory.Reserved)*inventory.AllocationRatio) - usages.Usages[rc]
+		n := capacity / amount
+		if n < 0 {
+			n = 0
+		}
+		fits[rc] = n
+	}
+	return fits, nil
+}
+
+// allocationCandidates returns the number of resource providers that placement reports can satisfy a request
+// for the resources.  Gophercloud doesn't have an implementation of this API, so we make the call directly.
+func allocationCandidates(placementClient *gophercloud.ServiceClient, resources map[string]int) (int, error) {
+	var body struct {
+		ProviderSummaries map[string]any `json:"provider_summaries"`
+	}
+	url := placementClient.ServiceURL("allocation_candidates") + "?resources=" + formatResources(resources)
+	_, err := placementClient.Get(url, &body, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(body.ProviderSummaries), nil
+}
+
+// formatResources returns the resources in the format used by the placement API, e.g. VCPU:1,MEMORY_MB:512
+func formatResources(resources map[string]int) string {
+	var list []string
+	for rc, amount := range resources {
+		list = append(list, fmt.Sprintf("%s:%d", rc, amount))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
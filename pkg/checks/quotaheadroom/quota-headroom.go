@@ -0,0 +1,208 @@
+// Package quotaheadroom implements a `checker.Check` that checks the quota usage of the authenticated project
+package quotaheadroom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	volumequotas "github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/quotasets"
+	computequotas "github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/quotasets"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	networkquotas "github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/quotas"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+// usage is the usage and limit of a single quota resource.  A limit of -1 means unlimited.
+type usage struct {
+	used  int
+	limit int
+}
+
+type checkQuotaHeadroom struct {
+	marginPercent int
+}
+
+// New returns a new Checker instance that checks the quota usage of the authenticated project
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkQuotaHeadroom{
+		marginPercent: 10,
+	}
+	if _, err := opts.Int(c.GetName(), "margin_percent", &c.marginPercent); err != nil {
+		return nil, err
+	}
+
+	if c.marginPercent < 0 || c.marginPercent > 100 {
+		return nil, errors.New("margin_percent must be between 0 and 100")
+	}
+
+	return c, nil
+}
+
+func (c *checkQuotaHeadroom) GetName() string {
+	return "quota_headroom"
+}
+
+func (c *checkQuotaHeadroom) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) error {
+
+	// find the project that we authenticated against
+
+	result, ok := providerClient.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return errors.New("no keystone v3 auth result available")
+	}
+	project, err := result.ExtractProject()
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return errors.New("token is not project-scoped")
+	}
+	fmt.Fprintln(output, "project", project.Name, project.ID)
+
+	// read the quota usage for each service
+
+	services := []struct {
+		name string
+		get  func(context.Context, *gophercloud.ProviderClient, string, string) (map[string]usage, error)
+	}{
+		{"compute", computeUsage},
+		{"network", networkUsage},
+		{"volume", volumeUsage},
+	}
+
+	var exceeded []string
+	for _, s := range services {
+		step := checker.StartStep(ctx, "get "+s.name+" quota")
+		usages, err := s.get(ctx, providerClient, region, project.ID)
+		if err != nil {
+			return step.End(err)
+		}
+		step.End(nil)
+
+		for _, resource := range sortedKeys(usages) {
+			u := usages[resource]
+			labels := map[string]string{
+				"service":  s.name,
+				"resource": resource,
+			}
+			checker.RecordMetric(ctx, checker.Metric{
+				Name:   "quota_usage",
+				Help:   "Quota usage of the project",
+				Labels: labels,
+				Value:  float64(u.used),
+			})
+			checker.RecordMetric(ctx, checker.Metric{
+				Name:   "quota_limit",
+				Help:   "Quota limit of the project, or -1 if unlimited",
+				Labels: labels,
+				Value:  float64(u.limit),
+			})
+
+			status := "ok"
+			if c.nearLimit(u) {
+				status = "near limit"
+				exceeded = append(exceeded, s.name+"/"+resource)
+			}
+			fmt.Fprintln(output, s.name, resource, "used", u.used, "limit", u.limit, status)
+		}
+	}
+
+	if len(exceeded) > 0 {
+		return fmt.Errorf("quota within %d%% of limit: %s", c.marginPercent, strings.Join(exceeded, ", "))
+	}
+
+	return nil
+}
+
+// nearLimit returns true if the usage is within the configured margin of the limit
+func (c *checkQuotaHeadroom) nearLimit(u usage) bool {
+	if u.limit <= 0 {
+		return false // unlimited, or the resource is deliberately disabled for the project
+	}
+	return u.used*100 >= u.limit*(100-c.marginPercent)
+}
+
+func computeUsage(ctx context.Context, providerClient *gophercloud.ProviderClient, region, projectID string) (map[string]usage, error) {
+	novaClient, err := openstack.NewComputeV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	novaClient.Context = ctx
+
+	q, err := computequotas.GetDetail(novaClient, projectID).Extract()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]usage{
+		"instances":       {q.Instances.InUse + q.Instances.Reserved, q.Instances.Limit},
+		"cores":           {q.Cores.InUse + q.Cores.Reserved, q.Cores.Limit},
+		"ram":             {q.RAM.InUse + q.RAM.Reserved, q.RAM.Limit},
+		"key_pairs":       {q.KeyPairs.InUse + q.KeyPairs.Reserved, q.KeyPairs.Limit},
+		"server_groups":   {q.ServerGroups.InUse + q.ServerGroups.Reserved, q.ServerGroups.Limit},
+		"metadata_items":  {q.MetadataItems.InUse + q.MetadataItems.Reserved, q.MetadataItems.Limit},
+		"injected_files":  {q.InjectedFiles.InUse + q.InjectedFiles.Reserved, q.InjectedFiles.Limit},
+		"security_groups": {q.SecurityGroups.InUse + q.SecurityGroups.Reserved, q.SecurityGroups.Limit},
+	}, nil
+}
+
+func networkUsage(ctx context.Context, providerClient *gophercloud.ProviderClient, region, projectID string) (map[string]usage, error) {
+	neutronClient, err := openstack.NewNetworkV2(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	neutronClient.Context = ctx
+
+	q, err := networkquotas.GetDetail(neutronClient, projectID).Extract()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]usage{
+		"floatingip":          {q.FloatingIP.Used + q.FloatingIP.Reserved, q.FloatingIP.Limit},
+		"network":             {q.Network.Used + q.Network.Reserved, q.Network.Limit},
+		"port":                {q.Port.Used + q.Port.Reserved, q.Port.Limit},
+		"rbac_policy":         {q.RBACPolicy.Used + q.RBACPolicy.Reserved, q.RBACPolicy.Limit},
+		"router":              {q.Router.Used + q.Router.Reserved, q.Router.Limit},
+		"security_group":      {q.SecurityGroup.Used + q.SecurityGroup.Reserved, q.SecurityGroup.Limit},
+		"security_group_rule": {q.SecurityGroupRule.Used + q.SecurityGroupRule.Reserved, q.SecurityGroupRule.Limit},
+		"subnet":              {q.Subnet.Used + q.Subnet.Reserved, q.Subnet.Limit},
+		"subnetpool":          {q.SubnetPool.Used + q.SubnetPool.Reserved, q.SubnetPool.Limit},
+	}, nil
+}
+
+func volumeUsage(ctx context.Context, providerClient *gophercloud.ProviderClient, region, projectID string) (map[string]usage, error) {
+	cinderClient, err := openstack.NewBlockStorageV3(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	cinderClient.Context = ctx
+
+	q, err := volumequotas.GetUsage(cinderClient, projectID).Extract()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]usage{
+		"volumes":          {q.Volumes.InUse + q.Volumes.Reserved, q.Volumes.Limit},
+		"snapshots":        {q.Snapshots.InUse + q.Snapshots.Reserved, q.Snapshots.Limit},
+		"gigabytes":        {q.Gigabytes.InUse + q.Gigabytes.Reserved, q.Gigabytes.Limit},
+		"backups":          {q.Backups.InUse + q.Backups.Reserved, q.Backups.Limit},
+		"backup_gigabytes": {q.BackupGigabytes.InUse + q.BackupGigabytes.Reserved, q.BackupGigabytes.Limit},
+		"groups":           {q.Groups.InUse + q.Groups.Reserved, q.Groups.Limit},
+	}, nil
+}
+
+// sortedKeys returns the keys of the map in sorted order, so that the output is stable
+func sortedKeys(m map[string]usage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,216 @@
+// Package swiftobjectroundtrip implements a `checker.Check` that uploads/downloads/deletes a Swift object
+package swiftobjectroundtrip
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // md5 is what swift uses for the ETag
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/containers"
+	"github.com/gophercloud/gophercloud/openstack/objectstorage/v1/objects"
+
+	"github.com/boyvinall/openstack-check-exporter/pkg/checker"
+)
+
+var (
+	// errETagMismatch is returned when the ETag returned by swift does not match the data that was uploaded
+	errETagMismatch = errors.New("object ETag does not match uploaded data")
+
+	// errDataMismatch is returned when the downloaded object does not match the data that was uploaded
+	errDataMismatch = errors.New("downloaded object does not match uploaded data")
+)
+
+// resourceObject is the type of resource that is created by this check
+const resourceObject = "object"
+
+type checkSwiftObjectRoundtrip struct {
+	containerName string
+	objectName    string
+	objectSize    int
+}
+
+// New returns a new Checker instance that uploads, downloads and deletes a Swift object
+func New(authOpts *gophercloud.AuthOptions, opts checker.CloudOptions) (checker.Checker, error) {
+	c := &checkSwiftObjectRoundtrip{
+		containerName: "monitoring-test",
+		objectName:    "monitoring-test",
+		objectSize:    64 * 1024,
+	}
+	if _, err := opts.String(c.GetName(), "container_name", &c.containerName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.ResourceName(c.GetName(), "object_name", &c.objectName); err != nil {
+		return nil, err
+	}
+	if _, err := opts.Int(c.GetName(), "object_size", &c.objectSize); err != nil {
+		return nil, err
+	}
+
+	if c.containerName == "" {
+		return nil, errors.New("container_name must be non-empty")
+	}
+	if c.objectName == "" {
+		return nil, errors.New("object_name must be non-empty")
+	}
+	if c.objectSize < 1 {
+		return nil, errors.New("object_size must be at least 1")
+	}
+
+	return c, nil
+}
+
+func (c *checkSwiftObjectRoundtrip) GetName() string {
+	return "swift_object_roundtrip"
+}
+
+// ResourceNames returns the names of the resources that the check creates
+func (c *checkSwiftObjectRoundtrip) ResourceNames() []string {
+	return []string{c.containerName + "/" + c.objectName}
+}
+
+func (c *checkSwiftObjectRoundtrip) Check(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, output *bytes.Buffer) (err error) {
+	swiftClient, err := openstack.NewObjectStorageV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	// don't set swiftClient.Context so we can cleanup the object even if the context is cancelled
+
+	// generate some random content
+
+	data := make([]byte, c.objectSize)
+	_, err = rand.Read(data)
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(data) //nolint:gosec // md5 is what swift uses for the ETag
+	checksum := hex.EncodeToString(sum[:])
+
+	// create the container – this is a no-op if it already exists
+
+	step := checker.StartStep(ctx, "create container")
+	_, err = containers.Create(swiftClient, c.containerName, containers.CreateOpts{}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	fmt.Fprintln(output, "using container", c.containerName)
+
+	// upload the object
+
+	step = checker.StartStep(ctx, "upload object")
+	owner := checker.GetOwner(ctx)
+	header, err := objects.Create(swiftClient, c.containerName, c.objectName, objects.CreateOpts{
+		Content:     bytes.NewReader(data),
+		ContentType: "application/octet-stream",
+		ETag:        checksum,
+		Metadata:    owner.Metadata(),
+	}).Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+	checker.TrackResource(ctx, checker.Resource{Type: resourceObject, ID: c.objectName, Name: c.objectName, Created: owner.Created})
+	fmt.Fprintln(output, "uploaded object", c.objectName, "size", len(data), "md5", checksum, "etag", header.ETag)
+
+	// always delete the object, even if the context is cancelled
+
+	defer func() {
+		step := checker.StartStep(ctx, "delete object")
+		e := step.End(objects.Delete(swiftClient, c.containerName, c.objectName, objects.DeleteOpts{}).Err)
+		if e == nil {
+			checker.ReleaseResource(ctx, resourceObject, c.objectName)
+			fmt.Fprintln(output, "deleted object", c.objectName)
+		}
+		if err == nil {
+			err = e
+		}
+	}()
+
+	if !strings.EqualFold(strings.Trim(header.ETag, `"`), checksum) {
+		return fmt.Errorf("%w: expected %s, got %s", errETagMismatch, checksum, header.ETag)
+	}
+
+	if ctx.Err() != nil {
+		return checker.ErrCancelled
+	}
+
+	// download the object and verify it
+
+	step = checker.StartStep(ctx, "download object")
+	download := objects.Download(swiftClient, c.containerName, c.objectName, objects.DownloadOpts{})
+	content, err := download.ExtractContent()
+	if err != nil {
+		return step.End(err)
+	}
+	downloadHeader, err := download.Extract()
+	if err != nil {
+		return step.End(err)
+	}
+	step.End(nil)
+
+	sum = md5.Sum(content) //nolint:gosec // md5 is what swift uses for the ETag
+	downloadChecksum := hex.EncodeToString(sum[:])
+	fmt.Fprintln(output, "downloaded object", c.objectName, "size", len(content), "md5", downloadChecksum, "etag", downloadHeader.ETag)
+
+	if !bytes.Equal(content, data) {
+		return fmt.Errorf("%w: expected md5 %s size %d, got md5 %s size %d", errDataMismatch, checksum, len(data), downloadChecksum, len(content))
+	}
+	if !strings.EqualFold(strings.Trim(downloadHeader.ETag, `"`), checksum) {
+		return fmt.Errorf("%w: expected %s, got %s", errETagMismatch, checksum, downloadHeader.ETag)
+	}
+
+	return nil
+}
+
+// ResourceTypes returns the types of resource that are created by this check
+func (c *checkSwiftObjectRoundtrip) ResourceTypes() []string {
+	return []string{resourceObject}
+}
+
+// ListResources returns the object if it was created by this check from this exporter instance.  The container
+// is not included, since it is expected to persist between runs.
+func (c *checkSwiftObjectRoundtrip) ListResources(ctx context.Context, providerClient *gophercloud.ProviderClient, region string) ([]checker.Resource, error) {
+	swiftClient, err := openstack.NewObjectStorageV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return nil, err
+	}
+	swiftClient.Context = ctx
+
+	metadata, err := objects.Get(swiftClient, c.containerName, c.objectName, objects.GetOpts{}).ExtractMetadata()
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	owner, ok := checker.OwnerFromMetadata(metadata)
+	if !ok || !owner.Matches(checker.OwnerFilter(ctx)) {
+		return nil, nil
+	}
+	return []checker.Resource{
+		{Type: resourceObject, ID: c.objectName, Name: c.objectName, Created: owner.Created},
+	}, nil
+}
+
+// DeleteResource deletes the object that was created by this check
+func (c *checkSwiftObjectRoundtrip) DeleteResource(ctx context.Context, providerClient *gophercloud.ProviderClient, region string, r checker.Resource, output *bytes.Buffer) error {
+	swiftClient, err := openstack.NewObjectStorageV1(providerClient, gophercloud.EndpointOpts{Region: region})
+	if err != nil {
+		return err
+	}
+	err = objects.Delete(swiftClient, c.containerName, r.ID, objects.DeleteOpts{}).Err
+	if _, notfound := err.(gophercloud.ErrDefault404); notfound {
+		return nil
+	}
+	if err == nil {
+		fmt.Fprintln(output, "deleted object", r.ID)
+	}
+	return err
+}
@@ -2,7 +2,39 @@ ID          {{.ID}}
 Start       {{.Start.UTC.Format "2006-01-02T15:04:05Z07:00"}}
 Duration    {{.Duration}}
 Cloud       {{.Cloud}}
+Region      {{.Region}}
 Name        {{.Name}}
 Error       {{.Error}}
+{{- if .Steps}}
 ----
-{{.Output}}
\ No newline at end of file
+Steps
+{{- range .Steps}}
+  {{waterfall $ .}} {{printf "%-30s" .Name}} {{duration .Duration}}{{if .Error}}  {{.Error}}{{end}}
+{{- end}}
+{{- end}}
+{{- if .Metrics}}
+----
+Metrics
+{{- range .Metrics}}
+  {{.Name}}{{if .Labels}}{{.Labels}}{{end}} {{.Value}}
+{{- end}}
+{{- end}}
+----
+{{.Output}}
+{{- if .Transcript}}
+----
+HTTP transcript
+{{- range .Transcript}}
+
+{{.Start.UTC.Format "15:04:05.000"}} {{.Method}} {{.URL}}
+  Status     {{if .Error}}{{.Error}}{{else}}{{.StatusCode}}{{end}}
+  Latency    {{duration .Latency}}
+  Request ID {{.RequestID}}
+{{- if .RequestBody}}
+  Request    {{.RequestBody}}
+{{- end}}
+{{- if .ResponseBody}}
+  Response   {{.ResponseBody}}
+{{- end}}
+{{- end}}
+{{- end}}
\ No newline at end of file
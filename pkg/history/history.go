@@ -5,6 +5,7 @@ import (
 	_ "embed"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"text/template"
 	"time"
@@ -53,7 +54,13 @@ func New(maxCount int) (*History, error) {
 	if err != nil {
 		return nil, err
 	}
-	detail, err := template.New("detail").Parse(detailTemplate)
+	detailFuncMap := template.FuncMap{
+		"waterfall": waterfall,
+		"duration": func(d time.Duration) time.Duration {
+			return d.Round(time.Millisecond)
+		},
+	}
+	detail, err := template.New("detail").Funcs(detailFuncMap).Parse(detailTemplate)
 	if err != nil {
 		return nil, err
 	}
@@ -64,6 +71,30 @@ func New(maxCount int) (*History, error) {
 	}, nil
 }
 
+// waterfall renders a text bar showing when the step ran relative to the whole check
+func waterfall(r result, step checker.Step) string {
+	const width = 50 // characters
+
+	if r.Duration <= 0 {
+		return "[" + strings.Repeat(" ", width) + "]"
+	}
+	offset := int(int64(width) * int64(step.Start.Sub(r.Start)) / int64(r.Duration))
+	length := int(int64(width) * int64(step.Duration) / int64(r.Duration))
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > width {
+		offset = width
+	}
+	if length < 1 {
+		length = 1
+	}
+	if offset+length > width {
+		length = width - offset
+	}
+	return "[" + strings.Repeat(" ", offset) + strings.Repeat("#", length) + strings.Repeat(" ", width-offset-length) + "]"
+}
+
 // Append adds a new check result to the history
 func (h *History) Append(r checker.CheckResult) {
 	h.lock.Lock()
@@ -37,6 +37,7 @@
 <tr>
     <th>Completed</th>
     <th>Cloud</th>
+    <th>Region</th>
     <th>Name</th>
     <th colspan="2">Duration</th>
     <th>Error</th>
@@ -46,6 +47,7 @@
     <tr>
         <td>{{(.Start.Add .Duration).UTC.Format "2006-01-02T15:04:05Z07:00"}}</td>
         <td>{{.Cloud}}</td>
+        <td>{{.Region}}</td>
         <td><a href="?name={{.Name}}">{{.Name}}</a></td>
         <td>{{duration .Duration}}</td>
         <td><div class="duration" style="width:{{width .Duration}}px">&nbsp;</div></td>
@@ -2,6 +2,9 @@
 package metrics
 
 import (
+	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -11,14 +14,28 @@ import (
 
 // Metrics implements a prometheus.Collector that exposes metrics about the checks that were run
 type Metrics struct {
-	healthy    *prometheus.GaugeVec
-	duration   *prometheus.GaugeVec
-	lastUpdate *prometheus.GaugeVec
+	healthy      *prometheus.GaugeVec
+	authFailed   *prometheus.GaugeVec
+	duration     *prometheus.GaugeVec
+	lastUpdate   *prometheus.GaugeVec
+	stepDuration *prometheus.GaugeVec
+
+	// check-specific metrics, from the latest result of each check
+	lock   sync.Mutex
+	custom map[checkKey][]checker.Metric
+}
+
+// checkKey identifies a single check within a single region of a cloud
+type checkKey struct {
+	name   string
+	cloud  string
+	region string
 }
 
 // New returns a new Metrics instance
 func New() *Metrics {
 	m := &Metrics{
+		custom: make(map[checkKey][]checker.Metric),
 		healthy: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "openstack_check_healthy",
@@ -27,6 +44,17 @@ func New() *Metrics {
 			[]string{
 				"name",
 				"cloud",
+				"region",
+			}),
+		authFailed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "openstack_check_auth_failed",
+				Help: "Whether the check failed because authentication failed, rather than the check itself",
+			},
+			[]string{
+				"name",
+				"cloud",
+				"region",
 			}),
 		duration: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -36,6 +64,7 @@ func New() *Metrics {
 			[]string{
 				"name",
 				"cloud",
+				"region",
 			}),
 		lastUpdate: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -45,12 +74,27 @@ func New() *Metrics {
 			[]string{
 				"name",
 				"cloud",
+				"region",
+			}),
+		stepDuration: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "openstack_check_step_duration_seconds",
+				Help: "How long each step within the check took to run",
+			},
+			[]string{
+				"name",
+				"cloud",
+				"region",
+				"step",
 			}),
 	}
 
 	prometheus.MustRegister(m.healthy)
+	prometheus.MustRegister(m.authFailed)
 	prometheus.MustRegister(m.duration)
 	prometheus.MustRegister(m.lastUpdate)
+	prometheus.MustRegister(m.stepDuration)
+	prometheus.MustRegister(m)
 	return m
 }
 
@@ -60,10 +104,59 @@ func (m *Metrics) Update(r checker.CheckResult) {
 	if r.Error != nil {
 		up = 0
 	}
+	authFailed := 0
+	if errors.Is(r.Error, checker.ErrAuthentication) {
+		authFailed = 1
+	}
 	duration := float64(r.Duration) / float64(time.Second)
 	end := r.Start.Add(r.Duration).UTC().Unix()
 
-	m.healthy.WithLabelValues(r.Name, r.Cloud).Set(float64(up))
-	m.duration.WithLabelValues(r.Name, r.Cloud).Set(duration)
-	m.lastUpdate.WithLabelValues(r.Name, r.Cloud).Set(float64(end))
+	m.healthy.WithLabelValues(r.Name, r.Cloud, r.Region).Set(float64(up))
+	m.authFailed.WithLabelValues(r.Name, r.Cloud, r.Region).Set(float64(authFailed))
+	m.duration.WithLabelValues(r.Name, r.Cloud, r.Region).Set(duration)
+	m.lastUpdate.WithLabelValues(r.Name, r.Cloud, r.Region).Set(float64(end))
+
+	for i := range r.Steps {
+		step := &r.Steps[i]
+		m.stepDuration.WithLabelValues(r.Name, r.Cloud, r.Region, step.Name).Set(float64(step.Duration) / float64(time.Second))
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.custom[checkKey{name: r.Name, cloud: r.Cloud, region: r.Region}] = r.Metrics
+}
+
+// Describe implements prometheus.Collector.  The check-specific metrics are not known
+// in advance, so nothing is sent – this makes it an "unchecked" collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector, exporting the check-specific metrics
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for key, metrics := range m.custom {
+		for i := range metrics {
+			metric := &metrics[i]
+
+			labelNames := make([]string, 0, len(metric.Labels)+3)
+			for k := range metric.Labels {
+				labelNames = append(labelNames, k)
+			}
+			sort.Strings(labelNames)
+			labelValues := make([]string, 0, len(labelNames)+3)
+			for _, k := range labelNames {
+				labelValues = append(labelValues, metric.Labels[k])
+			}
+			labelNames = append([]string{"name", "cloud", "region"}, labelNames...)
+			labelValues = append([]string{key.name, key.cloud, key.region}, labelValues...)
+
+			desc := prometheus.NewDesc("openstack_check_"+metric.Name, metric.Help, labelNames, nil)
+			pm, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, metric.Value, labelValues...)
+			if err != nil {
+				pm = prometheus.NewInvalidMetric(desc, err)
+			}
+			ch <- pm
+		}
+	}
 }